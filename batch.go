@@ -0,0 +1,121 @@
+package byteexec
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+)
+
+// ErrBatchAborted is the error of the runs in a batch that were skipped because
+// an earlier run failed in fail-fast mode.
+var ErrBatchAborted = errors.New("batch aborted after failure")
+
+// BatchOptions configures Exec.RunMany.
+type BatchOptions struct {
+	// Ordered makes RunMany deliver results in the order of the inputs instead
+	// of the order in which the runs complete. To bound the number of results
+	// kept in the meantime, runs don't start more than the concurrency ahead
+	// of the next result to deliver.
+	Ordered bool
+
+	// FailFast makes RunMany stop as soon as a run fails. Runs in progress are
+	// stopped and the remaining inputs are skipped with ErrBatchAborted.
+	// Otherwise all inputs are run regardless of errors.
+	FailFast bool
+}
+
+// BatchResult is the outcome of one run of a batch.
+type BatchResult struct {
+	// Index is the position of the input in the batch.
+	Index int
+
+	// Result and Err are the values Exec.Run returned for the input.
+	Result *Result
+	Err    error
+}
+
+// RunMany runs the helper once for every input, with up to concurrency runs at
+// the same time, or runtime.NumCPU() if concurrency isn't positive. It returns
+// a channel that receives exactly one BatchResult per input and is closed after
+// the last one. The channel must be read until it's closed. When ctx is done,
+// runs in progress are stopped and the remaining inputs fail with ctx.Err().
+func (be *Exec) RunMany(ctx context.Context, inputs []RunSpec, concurrency int, opts BatchOptions) <-chan BatchResult {
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+	if concurrency > len(inputs) {
+		concurrency = len(inputs)
+	}
+	results := make(chan BatchResult)
+	runCtx, abort := context.WithCancel(ctx)
+
+	// In order, results that complete early have to be kept until the ones
+	// before them are delivered, so don't let runs get too far ahead
+	var window chan struct{}
+	if opts.Ordered {
+		window = make(chan struct{}, concurrency)
+	}
+	indexes := make(chan int)
+	completed := make(chan BatchResult)
+	go func() {
+		for i := range inputs {
+			if window != nil {
+				window <- struct{}{}
+			}
+			indexes <- i
+		}
+		close(indexes)
+	}()
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				r := BatchResult{Index: i}
+				switch {
+				case ctx.Err() != nil:
+					r.Err = ctx.Err()
+				case runCtx.Err() != nil:
+					r.Err = ErrBatchAborted
+				default:
+					r.Result, r.Err = be.Run(runCtx, inputs[i])
+					if r.Err != nil && opts.FailFast {
+						abort()
+					}
+				}
+				completed <- r
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(completed)
+	}()
+
+	go func() {
+		defer close(results)
+		defer abort()
+		pending := make(map[int]BatchResult)
+		next := 0
+		for r := range completed {
+			if !opts.Ordered {
+				results <- r
+				continue
+			}
+			pending[r.Index] = r
+			for {
+				r, found := pending[next]
+				if !found {
+					break
+				}
+				delete(pending, next)
+				results <- r
+				<-window
+				next++
+			}
+		}
+	}()
+	return results
+}
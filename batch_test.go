@@ -0,0 +1,79 @@
+//go:build !windows
+// +build !windows
+
+package byteexec
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunMany(t *testing.T) {
+	be := testHelper(t, Options{})
+	codes := []string{"0", "1", "0", "2", "0"}
+	var inputs []RunSpec
+	for _, code := range codes {
+		inputs = append(inputs, RunSpec{Args: []string{"exit", code}})
+	}
+
+	var indexes []int
+	var failed []int
+	for r := range be.RunMany(context.Background(), inputs, 3, BatchOptions{Ordered: true}) {
+		indexes = append(indexes, r.Index)
+		if r.Err != nil {
+			failed = append(failed, r.Index)
+			assert.Equal(t, codes[r.Index], strconv.Itoa(r.Result.ExitCode))
+		}
+	}
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, indexes)
+	assert.Equal(t, []int{1, 3}, failed)
+
+	seen := make(map[int]bool)
+	aborted := 0
+	for r := range be.RunMany(context.Background(), inputs, 1, BatchOptions{FailFast: true}) {
+		seen[r.Index] = true
+		if r.Err == ErrBatchAborted {
+			aborted++
+		}
+	}
+	assert.Len(t, seen, len(inputs), "every input should get a result")
+	assert.Equal(t, 3, aborted, "inputs after the first failure should be skipped")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for r := range be.RunMany(ctx, inputs, 2, BatchOptions{}) {
+		assert.ErrorIs(t, r.Err, context.Canceled)
+	}
+}
+
+func TestRunManyOrderedWindow(t *testing.T) {
+	be := testHelper(t, Options{})
+	// A slow first input, followed by fast ones that leave a trace
+	inputs := []RunSpec{{Args: []string{"sleep"}, Timeout: 300 * time.Millisecond}}
+	var dirs []string
+	for i := 0; i < 8; i++ {
+		dir := t.TempDir()
+		dirs = append(dirs, dir)
+		inputs = append(inputs, RunSpec{Args: []string{"writefile", dir, "1"}})
+	}
+
+	results := be.RunMany(context.Background(), inputs, 2, BatchOptions{Ordered: true})
+	first := <-results
+	assert.Equal(t, 0, first.Index)
+	ran := 0
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, "file")); err == nil {
+			ran++
+		}
+	}
+	assert.LessOrEqual(t, ran, 2, "runs shouldn't get ahead of the slow one")
+	for r := range results {
+		assert.NoError(t, r.Err)
+	}
+}
@@ -20,21 +20,23 @@
 package byteexec
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/getlantern/filepersist"
-	"github.com/getlantern/golog"
 )
 
 var (
-	log = golog.LoggerFor("Exec")
-
-	initMutex sync.Mutex
+	// initMutex synchronizes file operations by this process. It's a channel
+	// rather than a sync.Mutex so that waiting for it can be abandoned.
+	initMutex = make(chan struct{}, 1)
 )
 
 // NewFileMode is the mode assigned to files passed to New.
@@ -44,6 +46,86 @@ const NewFileMode os.FileMode = 0744
 // using the Command method. Exec is safe for concurrent use.
 type Exec struct {
 	Filename string
+
+	opts           Options
+	privileges     *privilegesSpec
+	orphans        []Orphan
+	noCgroupOnce   sync.Once
+	noLandlockOnce sync.Once
+}
+
+// Options configures an Exec created with NewWithOptions or
+// ExistingWithOptions. The zero value is ready to use.
+type Options struct {
+	// Logger receives the Exec's log output. If nil, output goes to golog under
+	// the prefix "Exec".
+	Logger Logger
+
+	// Name identifies the helper in log output. If empty, the base name of the
+	// executable without extension is used.
+	Name string
+
+	// LogOutput sends every line a Process writes to stdout or stderr to
+	// Logger at info level, with the helper's name, the stream and the pid as
+	// fields. Output still goes wherever the exec.Cmd sends it, including
+	// files and pipes from StdoutPipe, but through a copy in this process
+	// rather than directly. Only commands started with Start are logged.
+	LogOutput bool
+
+	// StopSignal is sent to the process group of a Process when it is stopped.
+	// If nil, syscall.SIGTERM is used. On Windows, processes are always killed.
+	StopSignal os.Signal
+
+	// StopTimeout is how long a stopped Process and the rest of its process
+	// group get to exit after StopSignal before they are killed. If zero,
+	// DefaultStopTimeout is used.
+	StopTimeout time.Duration
+
+	// KillWithParent ties children to this process. Every exec.Cmd created by
+	// Command or CommandContext puts the child in its own process group, asks
+	// the kernel to kill the child when this process dies (Linux only) and
+	// registers the child so that Shutdown can kill it.
+	KillWithParent bool
+
+	// Orphans enables pidfiles for processes started with Start and determines
+	// what happens to helpers left over from previous runs when the Exec is
+	// created. Pidfiles are only supported on Linux.
+	Orphans OrphanPolicy
+
+	// SingleInstance, if set, limits the number of running instances of the
+	// helper started with Start to one.
+	SingleInstance *SingleInstance
+
+	// Limits, if set, are the resource limits of every helper started with
+	// an exec.Cmd from Command or CommandContext. Only Linux supports them,
+	// elsewhere the exec.Cmd fails to start.
+	Limits *Limits
+
+	// Cgroup, if set, confines every helper started with Start to a cgroup
+	// of its own, see Cgroup.
+	Cgroup *Cgroup
+
+	// Seccomp, if set, restricts the syscalls available to every helper
+	// started with an exec.Cmd from Command or CommandContext, see Seccomp.
+	Seccomp *Seccomp
+
+	// Landlock, if set, restricts the filesystem access of every helper
+	// started with an exec.Cmd from Command or CommandContext, see Landlock.
+	Landlock *Landlock
+
+	// Namespaces, if set, isolates every helper started with an exec.Cmd
+	// from Command or CommandContext in namespaces of its own, see
+	// Namespaces.
+	Namespaces *Namespaces
+
+	// Privileges, if set, makes every helper started with an exec.Cmd from
+	// Command or CommandContext run as an unprivileged user, see Privileges.
+	Privileges *Privileges
+
+	// Env, if set, determines the environment of every helper started with an
+	// exec.Cmd from Command or CommandContext instead of the environment of
+	// the calling process, see EnvPolicy.
+	Env *EnvPolicy
 }
 
 // New creates a new Exec using the program stored in the provided data, at the
@@ -64,8 +146,22 @@ type Exec struct {
 //	- Even when the file contents match the input data, the file mode will be
 //    changed to NewFileMode.
 func New(data []byte, filename string) (*Exec, error) {
-	log.Tracef("Creating new at %v", filename)
-	return loadExecutable(filename, data)
+	return NewWithOptions(data, filename, Options{})
+}
+
+// NewWithOptions is like New, but configures the Exec using opts.
+func NewWithOptions(data []byte, filename string, opts Options) (*Exec, error) {
+	return NewContext(context.Background(), data, filename, opts)
+}
+
+// NewContext is like NewWithOptions, but gives up once ctx is done. This is
+// useful for large programs, where writing the file can take a long time on
+// slow disks. The write itself can't be interrupted, so it carries on in the
+// background and a partially written file will be replaced on the next call to
+// New. The error wraps ctx.Err(), and also ErrWrite unless ctx was done before
+// writing began, while waiting for another Exec to be loaded.
+func NewContext(ctx context.Context, data []byte, filename string, opts Options) (*Exec, error) {
+	return loadExecutable(ctx, filename, data, opts)
 }
 
 // Existing is like New, but specifically for programs which already exist in
@@ -79,16 +175,58 @@ func New(data []byte, filename string) (*Exec, error) {
 // On Windows - %APPDATA%/byteexec
 // On OSX - ~/Library/Application Support/byteexec
 // All Others - ~/.byteexec
+//
+// If the file doesn't exist or isn't executable, Existing returns an error
+// wrapping ErrNotExecutable.
 func Existing(filename string) (*Exec, error) {
-	log.Tracef("Loading existing at %v", filename)
-	return loadExecutable(filename, nil)
+	return ExistingWithOptions(filename, Options{})
+}
+
+// ExistingWithOptions is like Existing, but configures the Exec using opts.
+func ExistingWithOptions(filename string, opts Options) (*Exec, error) {
+	return loadExecutable(context.Background(), filename, nil, opts)
+}
+
+type loadResult struct {
+	be  *Exec
+	err error
 }
 
 // If data is nil, we assume the file is to be loaded and not modified.
-func loadExecutable(filename string, data []byte) (*Exec, error) {
-	// Use initMutex to synchronize file operations by this process
-	initMutex.Lock()
-	defer initMutex.Unlock()
+func loadExecutable(ctx context.Context, filename string, data []byte, opts Options) (*Exec, error) {
+	log := opts.Logger
+	if log == nil {
+		log = defaultLogger
+	}
+
+	select {
+	case initMutex <- struct{}{}:
+	case <-ctx.Done():
+		// Nothing has been written yet, we only waited for another load
+		log.Error("gave up waiting to load executable", "path", filename, "error", ctx.Err())
+		return nil, fmt.Errorf("gave up waiting to load %s: %w", filename, ctx.Err())
+	}
+
+	// The file operations run in their own goroutine, which holds on to
+	// initMutex until they're finished even if we stop waiting for them.
+	result := make(chan loadResult, 1)
+	go func() {
+		defer func() { <-initMutex }()
+		be, err := doLoadExecutable(filename, data, opts, log)
+		result <- loadResult{be, err}
+	}()
+
+	select {
+	case r := <-result:
+		return r.be, r.err
+	case <-ctx.Done():
+		log.Error("gave up waiting to load executable", "path", filename, "error", ctx.Err())
+		return nil, fmt.Errorf("%w %s: %w", ErrWrite, filename, ctx.Err())
+	}
+}
+
+func doLoadExecutable(filename string, data []byte, opts Options, log Logger) (*Exec, error) {
+	start := time.Now()
 
 	var err error
 	if !filepath.IsAbs(filename) {
@@ -98,30 +236,130 @@ func loadExecutable(filename string, data []byte) (*Exec, error) {
 		}
 	}
 	filename = renameExecutable(filename)
+	// Deal with orphans first, since they could keep us from overwriting the
+	// executable.
+	orphans := findOrphans(filename, opts.Orphans, log)
 
 	if data != nil {
-		log.Tracef("Placing executable in %s", filename)
+		log.Debug("placing executable", "path", filename)
+		// filepersist leaves matching files alone, so an unchanged modification
+		// time tells us that the existing file was reused.
+		before, statErr := os.Stat(filename)
 		if err := filepersist.Save(filename, data, NewFileMode); err != nil {
+			log.Error("unable to save executable", "path", filename, "error", err)
+			return nil, fmt.Errorf("%w %s: %w", ErrWrite, filename, err)
+		}
+		if err := verifyContents(filename, data); err != nil {
+			log.Error("unable to verify executable", "path", filename, "error", err)
 			return nil, err
 		}
-		log.Trace("File saved, returning new Exec")
+		reused := false
+		if after, err := os.Stat(filename); statErr == nil && err == nil {
+			reused = os.SameFile(before, after) && before.ModTime().Equal(after.ModTime())
+		}
+		written := len(data)
+		if reused {
+			written = 0
+		}
+		log.Debug("saved executable", "path", filename, "bytes_written", written, "reused", reused, "duration", time.Since(start))
 	} else {
-		log.Tracef("Loading executable from %s", filename)
+		if err := verifyExecutable(filename); err != nil {
+			log.Error("unable to load executable", "path", filename, "error", err)
+			return nil, err
+		}
+		log.Debug("loaded existing executable", "path", filename, "duration", time.Since(start))
 	}
-	return newExec(filename)
+	be, err := newExec(filename, opts)
+	if err != nil {
+		return nil, err
+	}
+	be.orphans = orphans
+	return be, nil
 }
 
-// Command creates an exec.Cmd using the supplied args.
+// Command creates an exec.Cmd using the supplied args. If the helper has to be
+// started through the shim that applies settings such as Options.Limits, the
+// command's Path and Env are set up for that, so add to Env rather than
+// replacing it.
 func (be *Exec) Command(args ...string) *exec.Cmd {
-	return exec.Command(be.Filename, args...)
+	return be.command(nil, args)
+}
+
+// CommandContext is like Command, but the returned exec.Cmd is bound to ctx in
+// the same way as with exec.CommandContext.
+func (be *Exec) CommandContext(ctx context.Context, args ...string) *exec.Cmd {
+	if ctx == nil {
+		panic("nil Context")
+	}
+	return be.command(ctx, args)
 }
 
-func newExec(filename string) (*Exec, error) {
+// command creates the exec.Cmd for Command and CommandContext. ctx may be nil.
+func (be *Exec) command(ctx context.Context, args []string) *exec.Cmd {
+	var cmd *exec.Cmd
+	switch {
+	case be.opts.KillWithParent:
+		childCtx := newChildContext(ctx)
+		cmd = exec.CommandContext(childCtx, be.Filename, args...)
+		setProcessGroup(cmd)
+		childCtx.bind(cmd)
+		setParentDeathSignal(cmd)
+		cmd.Cancel = func() error {
+			return killGroup(cmd.Process)
+		}
+	case ctx == nil:
+		cmd = exec.Command(be.Filename, args...)
+	default:
+		cmd = exec.CommandContext(ctx, be.Filename, args...)
+	}
+	if be.opts.Env != nil {
+		cmd.Env = be.Environ()
+	}
+	be.useShim(cmd)
+	return cmd
+}
+
+func newExec(filename string, opts Options) (*Exec, error) {
 	absolutePath, err := filepath.Abs(filename)
 	if err != nil {
 		return nil, err
 	}
-	return &Exec{Filename: absolutePath}, nil
+	be := &Exec{Filename: absolutePath, opts: opts}
+	if opts.Privileges != nil {
+		be.privileges, err = resolvePrivileges(opts.Privileges)
+		if err != nil {
+			return nil, err
+		}
+		if err := be.privileges.allowExecute(absolutePath); err != nil {
+			return nil, fmt.Errorf("byteexec: unable to let uid %d execute %s: %w", be.privileges.UID, absolutePath, err)
+		}
+	}
+	return be, nil
+}
+
+// verifyContents makes sure that the file at filename contains exactly data.
+func verifyContents(filename string, data []byte) error {
+	saved, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("%w %s: %w", ErrIntegrity, filename, err)
+	}
+	if !bytes.Equal(saved, data) {
+		return fmt.Errorf("%w %s: expected %d bytes, found %d", ErrIntegrity, filename, len(data), len(saved))
+	}
+	return nil
+}
+
+// verifyExecutable makes sure that filename is a regular file that we can
+// execute.
+func verifyExecutable(filename string) error {
+	fileInfo, err := os.Stat(filename)
+	if err != nil {
+		return fmt.Errorf("%w %s: %w", ErrNotExecutable, filename, err)
+	}
+	if !fileInfo.Mode().IsRegular() || !isExecutable(fileInfo.Mode()) {
+		return fmt.Errorf("%w %s: mode is %v", ErrNotExecutable, filename, fileInfo.Mode())
+	}
+	return nil
 }
 
 func inStandardDir(filename string) (string, error) {
@@ -131,16 +369,15 @@ func inStandardDir(filename string) (string, error) {
 	}
 	err = os.MkdirAll(folder, NewFileMode)
 	if err != nil {
-		return "", fmt.Errorf("unable to make folder %s: %s", folder, err)
+		return "", fmt.Errorf("%w %s: %w", ErrMkdir, folder, err)
 	}
 	return filepath.Join(folder, filename), nil
 }
 
 func inHomeDir(filename string) (string, error) {
-	log.Tracef("Determining user's home directory")
 	usr, err := user.Current()
 	if err != nil {
-		return "", fmt.Errorf("unable to determine user's home directory: %s", err)
+		return "", fmt.Errorf("%w: %w", ErrHomeDir, err)
 	}
 	return filepath.Join(usr.HomeDir, filename), nil
 }
@@ -1,9 +1,12 @@
 package byteexec
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"runtime"
 	"sync"
 	"testing"
@@ -65,6 +68,45 @@ func TestExec(t *testing.T) {
 	assert.NotEqual(t, originalInfo.ModTime(), updatedInfo.ModTime(), "File modification time should be changed after creating new ByteExec on bad data")
 }
 
+func TestExistingNotExecutable(t *testing.T) {
+	dir := t.TempDir()
+	_, err := Existing(filepath.Join(dir, "missing"))
+	assert.True(t, errors.Is(err, ErrNotExecutable), "Missing file should not be executable, got %v", err)
+
+	if runtime.GOOS != "windows" {
+		filename := filepath.Join(dir, "notexecutable")
+		if err := ioutil.WriteFile(filename, []byte("Junk"), 0644); err != nil {
+			t.Fatalf("Unable to write file: %v", err)
+		}
+		_, err = Existing(filename)
+		assert.True(t, errors.Is(err, ErrNotExecutable), "File without executable mode should not be executable, got %v", err)
+	}
+}
+
+func TestNewContextCancelled(t *testing.T) {
+	data, err := Asset(program)
+	if err != nil {
+		t.Fatalf("Unable to read helloworld program: %s", err)
+	}
+
+	// Simulate a stuck write by another goroutine
+	initMutex <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = NewContext(ctx, data, filepath.Join(t.TempDir(), program), Options{})
+	<-initMutex
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "NewContext should have timed out, got %v", err)
+	assert.False(t, errors.Is(err, ErrWrite), "NewContext shouldn't have written anything, got %v", err)
+
+	be, err := NewContext(context.Background(), data, filepath.Join(t.TempDir(), program), Options{})
+	if err != nil {
+		t.Fatalf("Unable to create new ByteExec: %s", err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, be.CommandContext(ctx).Run(), "Running with a cancelled context should fail")
+}
+
 func createByteExec(t *testing.T, data []byte) *Exec {
 	// Sleep 1 second to give file timestamp a chance to increase
 	time.Sleep(1 * time.Second)
@@ -0,0 +1,353 @@
+package byteexec
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCacheSize is the default limit on the disk space used by a Cache.
+const DefaultCacheSize = 100 * 1024 * 1024
+
+// CacheOptions configures a Cache.
+type CacheOptions struct {
+	// Dir is the directory holding the cached results. If empty, a directory
+	// named after the executable with the suffix ".cache" is used.
+	Dir string
+
+	// MaxSize is the most disk space the cached results may use. Once it's
+	// exceeded, the least recently used results are evicted. If zero,
+	// DefaultCacheSize is used.
+	MaxSize int64
+
+	// Env lists the environment variables that affect the helper's output.
+	// Their values in the helper's environment are part of the cache key, as
+	// are the variables in RunSpec.Env.
+	Env []string
+}
+
+// Cache memoizes runs of a helper that always produces the same result for
+// the same input. Results are keyed by the digest of the executable, the
+// Exec's options that confine the helper or set its environment, the
+// arguments, the working directory, the values of the environment variables
+// listed in CacheOptions.Env, RunSpec.Env and the digest of stdin. The digest
+// of the executable is computed again whenever the file changes. Only runs
+// that exit on their own are cached, whether successfully or with an
+// ExitError. Runs that fail in any other way, time out or are terminated by a
+// signal are not, and neither are runs with RunSpec.Secrets or, if the helper
+// is started through the shim, runs that exit with shimExitCode, which then
+// usually means that the shim couldn't set up or execute the helper. Cache is
+// safe for concurrent use, also by several processes sharing the same
+// directory.
+type Cache struct {
+	be       *Exec
+	opts     CacheOptions
+	settings []byte
+	// shim is set if the helper is started through the shim.
+	shim bool
+
+	mu         sync.Mutex
+	executable os.FileInfo
+	digest     []byte
+}
+
+// cacheSettings are the options of an Exec that may change the helper's
+// results.
+type cacheSettings struct {
+	Limits     *Limits
+	Cgroup     *Cgroup
+	Seccomp    *Seccomp
+	Landlock   *Landlock
+	Namespaces *Namespaces
+	Privileges *Privileges
+	Env        *EnvPolicy
+}
+
+// cacheEntry is the serialized form of a cached Result.
+type cacheEntry struct {
+	ExitCode      int
+	Wall          time.Duration
+	User          time.Duration
+	System        time.Duration
+	MaxRSS        int64
+	Stdout        []byte
+	Stderr        []byte
+	StdoutDropped int64
+	StderrDropped int64
+	StderrTail    []byte
+}
+
+// Cache creates a Cache for runs of the helper.
+func (be *Exec) Cache(opts CacheOptions) (*Cache, error) {
+	if opts.Dir == "" {
+		opts.Dir = be.Filename + ".cache"
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultCacheSize
+	}
+	if err := os.MkdirAll(opts.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("%w %s: %w", ErrMkdir, opts.Dir, err)
+	}
+	settings, err := json.Marshal(cacheSettings{
+		Limits:     be.opts.Limits,
+		Cgroup:     be.opts.Cgroup,
+		Seccomp:    be.opts.Seccomp,
+		Landlock:   be.opts.Landlock,
+		Namespaces: be.opts.Namespaces,
+		Privileges: be.opts.Privileges,
+		Env:        be.opts.Env,
+	})
+	if err != nil {
+		return nil, err
+	}
+	shim, err := be.shimSpec()
+	c := &Cache{be: be, opts: opts, settings: settings, shim: err != nil || shim != nil}
+	if _, err := c.executableDigest(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// executableDigest returns the digest of the executable, hashing it again if
+// it changed since the last time.
+func (c *Cache) executableDigest() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	file, err := os.Open(c.be.Filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read executable: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read executable: %w", err)
+	}
+	if c.executable != nil && os.SameFile(info, c.executable) && info.Size() == c.executable.Size() &&
+		info.ModTime().Equal(c.executable.ModTime()) {
+		return c.digest, nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return nil, fmt.Errorf("unable to read executable: %w", err)
+	}
+	c.executable = info
+	c.digest = h.Sum(nil)
+	return c.digest, nil
+}
+
+// Run returns the cached result for spec if there is one, or else runs the
+// helper like Exec.Run and caches the result. Stdin is read completely before
+// the helper starts in order to compute its digest. Failing to read or write
+// the cache doesn't fail the run.
+func (c *Cache) Run(ctx context.Context, spec RunSpec) (*Result, error) {
+	if len(spec.Secrets) > 0 {
+		// Keying results by secrets would leave traces of them on disk
+		return c.be.Run(ctx, spec)
+	}
+	key, err := c.key(&spec)
+	if err != nil {
+		return nil, err
+	}
+	log := c.be.logger()
+	path := filepath.Join(c.opts.Dir, key+".json")
+	if entry, err := c.load(path); err == nil {
+		log.Debug("using cached result", "path", c.be.Filename, "key", key)
+		return entry.result()
+	} else if !os.IsNotExist(err) {
+		log.Debug("ignoring unreadable cached result", "path", path, "error", err)
+	}
+
+	result, err := c.be.Run(ctx, spec)
+	var exitErr *ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return result, err
+	}
+	if result.TimedOut || result.Signal != nil || ctx.Err() != nil || c.shim && result.ExitCode == shimExitCode {
+		return result, err
+	}
+	entry := &cacheEntry{
+		ExitCode:      result.ExitCode,
+		Wall:          result.Wall,
+		User:          result.User,
+		System:        result.System,
+		MaxRSS:        result.MaxRSS,
+		Stdout:        result.Stdout,
+		Stderr:        result.Stderr,
+		StdoutDropped: result.StdoutDropped,
+		StderrDropped: result.StderrDropped,
+	}
+	if exitErr != nil {
+		entry.StderrTail = exitErr.StderrTail
+	}
+	if storeErr := c.store(path, entry); storeErr != nil {
+		log.Error("unable to cache result", "path", path, "error", storeErr)
+	}
+	return result, err
+}
+
+// key computes the cache key for spec, replacing its Stdin with a reader for
+// the data that was hashed.
+func (c *Cache) key(spec *RunSpec) (string, error) {
+	digest, err := c.executableDigest()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write(digest)
+	writeField(h, c.settings)
+	writeField(h, []byte(fmt.Sprint(len(spec.Args))))
+	for _, arg := range spec.Args {
+		writeField(h, []byte(arg))
+	}
+	writeField(h, []byte(spec.Dir))
+	env := make(map[string]string)
+	for _, kv := range c.be.Environ(spec.Env...) {
+		key, _, _ := strings.Cut(kv, "=")
+		env[key] = kv
+	}
+	for _, name := range c.opts.Env {
+		if kv, found := env[name]; found {
+			writeField(h, []byte(kv))
+		} else {
+			writeField(h, []byte(name))
+		}
+	}
+	// Variables set for this run are likely to matter
+	writeField(h, []byte(fmt.Sprint(len(spec.Env))))
+	for _, kv := range spec.Env {
+		writeField(h, []byte(kv))
+	}
+	if spec.Stdin != nil {
+		stdin, err := io.ReadAll(spec.Stdin)
+		if err != nil {
+			return "", fmt.Errorf("unable to read stdin: %w", err)
+		}
+		spec.Stdin = bytes.NewReader(stdin)
+		digest := sha256.Sum256(stdin)
+		writeField(h, digest[:])
+	} else {
+		writeField(h, nil)
+	}
+	// Runs with different output limits capture different output
+	writeField(h, []byte(fmt.Sprint(spec.OutputLimit)))
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeField hashes b prefixed with its length, so that consecutive fields
+// can't run into each other.
+func writeField(h hash.Hash, b []byte) {
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(len(b)))
+	h.Write(size[:])
+	h.Write(b)
+}
+
+func (c *Cache) load(path string) (*cacheEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+	// Mark the entry as recently used for eviction
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return entry, nil
+}
+
+func (c *Cache) store(path string, entry *cacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first, so that concurrent readers never see a
+	// partial entry
+	tmp, err := os.CreateTemp(c.opts.Dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return c.evict()
+}
+
+// evict removes the least recently used entries until the cache fits into
+// CacheOptions.MaxSize.
+func (c *Cache) evict() error {
+	dirEntries, err := os.ReadDir(c.opts.Dir)
+	if err != nil {
+		return err
+	}
+	var files []os.FileInfo
+	var total int64
+	for _, dirEntry := range dirEntries {
+		if !strings.HasSuffix(dirEntry.Name(), ".json") {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			// Probably evicted by someone else in the meantime
+			continue
+		}
+		files = append(files, info)
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	for _, info := range files {
+		if total <= c.opts.MaxSize {
+			break
+		}
+		path := filepath.Join(c.opts.Dir, info.Name())
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		c.be.logger().Debug("evicted cached result", "path", path, "bytes", info.Size())
+		total -= info.Size()
+	}
+	return nil
+}
+
+func (entry *cacheEntry) result() (*Result, error) {
+	result := &Result{
+		ExitCode:      entry.ExitCode,
+		Wall:          entry.Wall,
+		User:          entry.User,
+		System:        entry.System,
+		MaxRSS:        entry.MaxRSS,
+		Stdout:        entry.Stdout,
+		Stderr:        entry.Stderr,
+		StdoutDropped: entry.StdoutDropped,
+		StderrDropped: entry.StderrDropped,
+		Cached:        true,
+	}
+	if !result.Success() {
+		return result, &ExitError{Result: result, StderrTail: entry.StderrTail}
+	}
+	return result, nil
+}
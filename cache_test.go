@@ -0,0 +1,135 @@
+//go:build !windows
+// +build !windows
+
+package byteexec
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	be := testHelper(t, Options{})
+	dir := t.TempDir()
+	cache, err := be.Cache(CacheOptions{Dir: dir, Env: []string{"BYTEEXEC_TEST_CACHE"}})
+	if err != nil {
+		t.Fatalf("Unable to create cache: %v", err)
+	}
+	ctx := context.Background()
+	run := func(stdin string) *Result {
+		result, err := cache.Run(ctx, RunSpec{Args: []string{"cat"}, Stdin: strings.NewReader(stdin)})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		assert.Equal(t, stdin, string(result.Stdout))
+		return result
+	}
+
+	assert.False(t, run("a").Cached)
+	assert.True(t, run("a").Cached)
+	assert.False(t, run("b").Cached, "different stdin should miss")
+	t.Setenv("BYTEEXEC_TEST_CACHE", "1")
+	assert.False(t, run("a").Cached, "different environment should miss")
+	assert.True(t, run("a").Cached)
+	result, err := cache.Run(ctx, RunSpec{Args: []string{"cat"}, Stdin: strings.NewReader("a"), Env: []string{"BYTEEXEC_OTHER=1"}})
+	if assert.NoError(t, err) {
+		assert.False(t, result.Cached, "different run environment should miss")
+	}
+
+	// Failures are cached along with their stderr
+	for i := 0; i < 2; i++ {
+		result, err := cache.Run(ctx, RunSpec{Args: []string{"exit", "3"}})
+		var exitErr *ExitError
+		if assert.ErrorAs(t, err, &exitErr) {
+			assert.Equal(t, 3, result.ExitCode)
+			assert.Equal(t, i == 1, result.Cached)
+			assert.Contains(t, err.Error(), "stderr: stderr")
+		}
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	be := testHelper(t, Options{})
+	dir := t.TempDir()
+	cache, err := be.Cache(CacheOptions{Dir: dir})
+	if err != nil {
+		t.Fatalf("Unable to create cache: %v", err)
+	}
+	ctx := context.Background()
+	_, err = cache.Run(ctx, RunSpec{Args: []string{"exit", "0"}})
+	assert.NoError(t, err)
+	entries, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if !assert.Len(t, entries, 1) {
+		return
+	}
+	info, _ := os.Stat(entries[0])
+
+	// Leave room for a little more than one entry
+	cache.opts.MaxSize = info.Size() * 3 / 2
+	_, err = cache.Run(ctx, RunSpec{Args: []string{"exit", "0", "other"}})
+	assert.NoError(t, err)
+	remaining, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if assert.Len(t, remaining, 1) {
+		assert.NotEqual(t, entries[0], remaining[0], "oldest entry should have been evicted")
+	}
+}
+
+func TestCacheKeys(t *testing.T) {
+	helper := testHelper(t, Options{})
+	data, err := os.ReadFile(helper.Filename)
+	if !assert.NoError(t, err) {
+		return
+	}
+	filename := filepath.Join(t.TempDir(), helperName)
+	if !assert.NoError(t, os.WriteFile(filename, data, 0755)) {
+		return
+	}
+	dir := t.TempDir()
+	newCache := func(opts Options) *Cache {
+		cache, err := (&Exec{Filename: filename, opts: opts}).Cache(CacheOptions{Dir: dir})
+		if err != nil {
+			t.Fatalf("Unable to create cache: %v", err)
+		}
+		return cache
+	}
+	cache := newCache(Options{})
+	ctx := context.Background()
+	cached := func(cache *Cache, args ...string) bool {
+		result, _ := cache.Run(ctx, RunSpec{Args: args})
+		if !assert.NotNil(t, result) {
+			t.FailNow()
+		}
+		return result.Cached
+	}
+
+	assert.False(t, cached(cache, "exit", "0"))
+	assert.True(t, cached(cache, "exit", "0"))
+	limited := newCache(Options{Limits: &Limits{CoreSize: NoCore}})
+	assert.False(t, cached(limited, "exit", "0"), "different options should miss")
+	assert.True(t, cached(limited, "exit", "0"))
+
+	// Appending to an executable doesn't keep it from running
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	file.Write([]byte("changed"))
+	file.Close()
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filename, later, later)
+	assert.False(t, cached(cache, "exit", "0"), "changed executable should miss")
+	assert.True(t, cached(cache, "exit", "0"))
+
+	assert.False(t, cached(cache, "exit", "1"))
+	assert.True(t, cached(cache, "exit", "1"), "failing runs should be cached")
+	assert.False(t, cached(cache, "exit", "127"))
+	assert.True(t, cached(cache, "exit", "127"), "exit code 127 should be cached without the shim")
+	assert.False(t, cached(limited, "exit", "127"))
+	assert.False(t, cached(limited, "exit", "127"), "exit code 127 shouldn't be cached with the shim")
+}
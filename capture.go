@@ -0,0 +1,115 @@
+package byteexec
+
+import (
+	"fmt"
+	"sync"
+)
+
+// DefaultCaptureLimit is the number of bytes Run keeps from both the start and
+// the end of each output stream of a helper.
+const DefaultCaptureLimit = 64 * 1024
+
+// Capture is an io.Writer that keeps only the first and the last bytes written
+// to it, counting the bytes it drops in between. This bounds the memory used to
+// capture the output of a helper no matter how much it writes. Capture is safe
+// for concurrent use.
+type Capture struct {
+	mu        sync.Mutex
+	headLimit int
+	tailLimit int
+	head      []byte
+	tail      []byte
+	total     int64
+}
+
+// NewCapture creates a Capture that keeps the first headLimit and the last
+// tailLimit bytes written to it.
+func NewCapture(headLimit, tailLimit int) *Capture {
+	return &Capture{headLimit: headLimit, tailLimit: tailLimit}
+}
+
+// Write implements io.Writer. It never fails.
+func (c *Capture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total += int64(len(p))
+	if room := c.headLimit - len(c.head); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		c.head = append(c.head, p[:room]...)
+	}
+	if c.tailLimit <= 0 {
+		return len(p), nil
+	}
+
+	// The tail covers the last bytes of everything written, including what's
+	// also in the head. Compact only once it has grown to twice the limit, so
+	// that copying stays proportional to the amount written.
+	if len(p) >= c.tailLimit {
+		c.tail = append(c.tail[:0], p[len(p)-c.tailLimit:]...)
+	} else {
+		c.tail = append(c.tail, p...)
+		if len(c.tail) >= 2*c.tailLimit {
+			c.tail = append(c.tail[:0], c.tail[len(c.tail)-c.tailLimit:]...)
+		}
+	}
+	return len(p), nil
+}
+
+// Head returns the first bytes written, up to the head limit.
+func (c *Capture) Head() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]byte(nil), c.head...)
+}
+
+// Tail returns the last bytes written, up to the tail limit.
+func (c *Capture) Tail() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]byte(nil), c.trimmedTail()...)
+}
+
+// Bytes returns the head followed by the part of the tail that doesn't overlap
+// with it. Nothing marks where bytes were dropped, use Dropped to find out.
+func (c *Capture) Bytes() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tail := c.trimmedTail()
+	overlap := int64(len(c.head)+len(tail)) - c.total
+	if overlap < 0 {
+		overlap = 0
+	}
+	result := make([]byte, 0, int64(len(c.head)+len(tail))-overlap)
+	result = append(result, c.head...)
+	return append(result, tail[overlap:]...)
+}
+
+// Dropped returns the number of bytes that were neither kept in the head nor in
+// the tail.
+func (c *Capture) Dropped() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	dropped := c.total - int64(len(c.head)+len(c.trimmedTail()))
+	if dropped < 0 {
+		return 0
+	}
+	return dropped
+}
+
+// String returns the captured bytes with a marker where bytes were dropped.
+func (c *Capture) String() string {
+	dropped := c.Dropped()
+	if dropped == 0 {
+		return string(c.Bytes())
+	}
+	return fmt.Sprintf("%s\n... %d bytes dropped ...\n%s", c.Head(), dropped, c.Tail())
+}
+
+func (c *Capture) trimmedTail() []byte {
+	if len(c.tail) > c.tailLimit {
+		return c.tail[len(c.tail)-c.tailLimit:]
+	}
+	return c.tail
+}
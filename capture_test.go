@@ -0,0 +1,37 @@
+package byteexec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapture(t *testing.T) {
+	c := NewCapture(4, 6)
+	c.Write([]byte("abc"))
+	assert.Equal(t, "abc", string(c.Bytes()))
+	assert.EqualValues(t, 0, c.Dropped())
+
+	c.Write([]byte("defgh"))
+	assert.Equal(t, "abcdefgh", string(c.Bytes()), "Head and tail should overlap without duplication")
+	assert.EqualValues(t, 0, c.Dropped())
+
+	for i := 0; i < 10; i++ {
+		c.Write([]byte("ij"))
+	}
+	c.Write([]byte("klmnopq"))
+	assert.Equal(t, "abcd", string(c.Head()))
+	assert.Equal(t, "lmnopq", string(c.Tail()))
+	assert.Equal(t, "abcdlmnopq", string(c.Bytes()))
+	assert.EqualValues(t, 35-10, c.Dropped())
+	assert.Equal(t, "abcd\n... 25 bytes dropped ...\nlmnopq", c.String())
+}
+
+func TestExitErrorMessage(t *testing.T) {
+	err := &ExitError{Result: &Result{ExitCode: 2}, StderrTail: []byte("boom\n")}
+	assert.Equal(t, "helper exited with code 2: stderr: boom", err.Error())
+
+	err.StderrTail = []byte(strings.Repeat("x", 1000) + "\nlast line\n")
+	assert.Equal(t, "helper exited with code 2: stderr: ...last line", err.Error())
+}
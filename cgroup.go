@@ -0,0 +1,73 @@
+package byteexec
+
+import (
+	"errors"
+)
+
+// ErrCgroupUnavailable indicates that helpers can't be confined to a cgroup,
+// for example because cgroup v2 isn't mounted, the parent cgroup isn't writable
+// or a required controller isn't delegated to it.
+var ErrCgroupUnavailable = errors.New("cgroup unavailable")
+
+// NoSwap can be used as Cgroup.SwapMax to keep helpers from swapping.
+const NoSwap = -1
+
+// Cgroup confines every helper started with Start to a cgroup v2 of its own,
+// created as a child of a delegated cgroup. Unlike Limits, the cgroup bounds
+// the resources used by the helper and all of its descendants together. When
+// the helper exits, whatever is left in its cgroup is killed and the cgroup is
+// removed. Cgroups are only supported on Linux. Where the kernel can't start
+// the helper in its cgroup, such as before Linux 5.7, the helper is moved
+// there once it has started, so it briefly runs outside.
+type Cgroup struct {
+	// Parent is the directory of the cgroup under which the helpers' cgroups
+	// are created, for example /sys/fs/cgroup/user.slice/myapp.service. It
+	// must be writable and must not contain processes itself, unless the
+	// controllers needed are enabled in its cgroup.subtree_control already.
+	// If empty, the cgroup of the calling process is used, see MoveCaller.
+	Parent string
+
+	// MoveCaller allows moving the calling process to a child cgroup named
+	// byteexec-caller when Parent is empty and the cgroup of the calling
+	// process contains processes, which keep controllers from being enabled
+	// for the helpers' cgroups. The move affects the whole calling process and
+	// only helps if no other processes share its cgroup. Without MoveCaller,
+	// the cgroup is unavailable in that case.
+	MoveCaller bool
+
+	// MemoryMax, if positive, is the memory.max of the helper's cgroup in
+	// bytes. The kernel's OOM killer steps in when the helper exceeds it,
+	// unless the helper can swap instead, see SwapMax.
+	MemoryMax int64
+
+	// SwapMax, if positive, is the memory.swap.max of the helper's cgroup in
+	// bytes. Use NoSwap to keep the helper from swapping at all. If zero, the
+	// helper may swap as much as its parent cgroup allows.
+	SwapMax int64
+
+	// CPUMax, if positive, is the number of CPUs the helper can use, for
+	// example 0.5 for half of one CPU. It sets cpu.max.
+	CPUMax float64
+
+	// PidsMax, if positive, is the pids.max of the helper's cgroup, the most
+	// processes and threads it may have.
+	PidsMax int64
+
+	// Required makes Start fail with ErrCgroupUnavailable if the helper can't
+	// be confined. Otherwise, the helper is started without a cgroup and the
+	// problem is logged.
+	Required bool
+}
+
+// cgroupUsage is what the cgroup of a helper reports about its run.
+type cgroupUsage struct {
+	memoryPeak int64
+	oomKills   int
+}
+
+// warnNoCgroup logs that helpers run without a cgroup, once per Exec.
+func (be *Exec) warnNoCgroup(err error) {
+	be.noCgroupOnce.Do(func() {
+		be.logger().Error("starting helpers without cgroup", "path", be.Filename, "error", err)
+	})
+}
@@ -0,0 +1,395 @@
+package byteexec
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// cgroupPeriod is the period of cpu.max in microseconds.
+const cgroupPeriod = 100000
+
+// cgroupSeq makes the names of cgroups created by this process unique.
+var cgroupSeq int64
+
+// callerCgroup is the cgroup the calling process moves to if its own cgroup
+// is the default parent but contains processes and Cgroup.MoveCaller allows
+// it, see ownParent.
+const callerCgroup = "byteexec-caller"
+
+var (
+	ownParentMu  sync.Mutex
+	ownParentDir string
+)
+
+var (
+	cloneProbeOnce sync.Once
+	cloneProbeOK   bool
+)
+
+// cloneIntoCgroup reports whether children can be started right in the cgroup
+// opened as dir. This needs clone3, which supports it since Linux 5.7, and may
+// still be blocked, for example by the seccomp filter of a container. It's
+// found out once, by starting the shim in dir with nothing to do.
+func cloneIntoCgroup(dir *os.File) bool {
+	cloneProbeOnce.Do(func() {
+		var uts unix.Utsname
+		if err := unix.Uname(&uts); err != nil {
+			return
+		}
+		major, minor := kernelVersion(unix.ByteSliceToString(uts.Release[:]))
+		if major < 5 || major == 5 && minor < 7 {
+			return
+		}
+		cmd := exec.Command("/proc/self/exe")
+		useShimSpec(cmd, &shimSpec{})
+		cmd.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: int(dir.Fd())}
+		cloneProbeOK = cmd.Run() == nil
+	})
+	return cloneProbeOK
+}
+
+// cgroup is the cgroup of a single helper.
+type cgroup struct {
+	path string
+	dir  *os.File
+	// moved is set if the helper has to be moved to the cgroup once it has
+	// started, because the kernel can't start it there.
+	moved bool
+}
+
+// prepareCgroup creates a cgroup for the helper that cmd starts and arranges
+// for cmd to start in it. It returns nil if no cgroup is configured, or if it
+// can't be created and isn't required.
+func (be *Exec) prepareCgroup(cmd *exec.Cmd) (*cgroup, error) {
+	spec := be.opts.Cgroup
+	if spec == nil {
+		return nil, nil
+	}
+	cg, err := be.newCgroup(spec)
+	if err != nil {
+		if spec.Required {
+			return nil, err
+		}
+		be.warnNoCgroup(err)
+		return nil, nil
+	}
+	if !cloneIntoCgroup(cg.dir) {
+		cg.moved = true
+		return cg, nil
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(cg.dir.Fd())
+	return cg, nil
+}
+
+func (be *Exec) newCgroup(spec *Cgroup) (*cgroup, error) {
+	controllers := []string{"memory"}
+	if spec.CPUMax > 0 {
+		controllers = append(controllers, "cpu")
+	}
+	if spec.PidsMax > 0 {
+		controllers = append(controllers, "pids")
+	}
+	parent := spec.Parent
+	if parent == "" {
+		var err error
+		parent, err = ownParent(controllers, spec.MoveCaller)
+		if err != nil {
+			return nil, err
+		}
+	} else if err := enableControllers(parent, controllers); err != nil {
+		return nil, err
+	}
+	removeStaleCgroups(parent)
+
+	name := fmt.Sprintf("byteexec-%s-%d-%d", be.helperName(), os.Getpid(), atomic.AddInt64(&cgroupSeq, 1))
+	cg := &cgroup{path: filepath.Join(parent, name)}
+	if err := os.Mkdir(cg.path, 0755); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCgroupUnavailable, err)
+	}
+	settings := make(map[string]string)
+	if spec.MemoryMax > 0 {
+		settings["memory.max"] = strconv.FormatInt(spec.MemoryMax, 10)
+	}
+	switch {
+	case spec.SwapMax > 0:
+		settings["memory.swap.max"] = strconv.FormatInt(spec.SwapMax, 10)
+	case spec.SwapMax == NoSwap:
+		settings["memory.swap.max"] = "0"
+	}
+	if spec.CPUMax > 0 {
+		settings["cpu.max"] = fmt.Sprintf("%d %d", int64(spec.CPUMax*cgroupPeriod), cgroupPeriod)
+	}
+	if spec.PidsMax > 0 {
+		settings["pids.max"] = strconv.FormatInt(spec.PidsMax, 10)
+	}
+	for file, value := range settings {
+		err := os.WriteFile(filepath.Join(cg.path, file), []byte(value), 0)
+		// Without swap accounting, there's no swap to forbid
+		if err != nil && !(file == "memory.swap.max" && spec.SwapMax == NoSwap && errors.Is(err, os.ErrNotExist)) {
+			os.Remove(cg.path)
+			return nil, fmt.Errorf("%w: unable to set %s: %w", ErrCgroupUnavailable, file, err)
+		}
+	}
+	dir, err := os.Open(cg.path)
+	if err != nil {
+		os.Remove(cg.path)
+		return nil, fmt.Errorf("%w: %w", ErrCgroupUnavailable, err)
+	}
+	cg.dir = dir
+	return cg, nil
+}
+
+// started is called once the helper has started with the given pid, or failed
+// to, in which case pid is 0. If the kernel couldn't start the helper in the
+// cgroup, it's moved there now, which leaves a short window in which it runs
+// outside.
+func (cg *cgroup) started(pid int) error {
+	cg.dir.Close()
+	if !cg.moved || pid == 0 {
+		return nil
+	}
+	err := os.WriteFile(filepath.Join(cg.path, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0)
+	if err != nil {
+		return fmt.Errorf("%w: unable to move helper to %s: %w", ErrCgroupUnavailable, cg.path, err)
+	}
+	return nil
+}
+
+// usage reads what the cgroup reports about the helper's run. Counters that
+// the kernel doesn't provide are left at zero.
+func (cg *cgroup) usage() cgroupUsage {
+	var usage cgroupUsage
+	if peak, err := os.ReadFile(filepath.Join(cg.path, "memory.peak")); err == nil {
+		usage.memoryPeak, _ = strconv.ParseInt(strings.TrimSpace(string(peak)), 10, 64)
+	}
+	if events, err := readKeyedFile(filepath.Join(cg.path, "memory.events")); err == nil {
+		usage.oomKills = int(events["oom_kill"])
+	}
+	return usage
+}
+
+// remove kills whatever is left in the cgroup and removes it.
+func (cg *cgroup) remove() error {
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = os.Remove(cg.path); err == nil || errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if !errors.Is(err, syscall.EBUSY) {
+			return err
+		}
+		killCgroup(cg.path)
+		time.Sleep(20 * time.Millisecond)
+	}
+	return err
+}
+
+// killCgroup kills all processes in the cgroup at path.
+func killCgroup(path string) {
+	// cgroup.kill exists since Linux 5.14, before that we kill one by one
+	if os.WriteFile(filepath.Join(path, "cgroup.kill"), []byte("1"), 0) == nil {
+		return
+	}
+	procs, err := os.ReadFile(filepath.Join(path, "cgroup.procs"))
+	if err != nil {
+		return
+	}
+	for _, field := range strings.Fields(string(procs)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}
+}
+
+// removeStaleCgroups removes empty cgroups left behind under parent by
+// processes that are no longer running.
+func removeStaleCgroups(parent string) {
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		fields := strings.Split(entry.Name(), "-")
+		if !entry.IsDir() || len(fields) < 4 || fields[0] != "byteexec" {
+			continue
+		}
+		creator, err := strconv.Atoi(fields[len(fields)-2])
+		if err != nil || creator == os.Getpid() || processExists(creator) {
+			continue
+		}
+		// Fails if there are still processes in it
+		os.Remove(filepath.Join(parent, entry.Name()))
+	}
+}
+
+// enableControllers makes sure that the given controllers are available to
+// the children of the cgroup at parent.
+func enableControllers(parent string, controllers []string) error {
+	available, err := os.ReadFile(filepath.Join(parent, "cgroup.controllers"))
+	if err != nil {
+		return fmt.Errorf("%w: %s is not a cgroup v2: %w", ErrCgroupUnavailable, parent, err)
+	}
+	enabled, err := os.ReadFile(filepath.Join(parent, "cgroup.subtree_control"))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCgroupUnavailable, err)
+	}
+	for _, controller := range controllers {
+		if hasField(string(enabled), controller) {
+			continue
+		}
+		if !hasField(string(available), controller) {
+			return fmt.Errorf("%w: %s controller not delegated to %s", ErrCgroupUnavailable, controller, parent)
+		}
+		err := os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte("+"+controller), 0)
+		if errors.Is(err, syscall.EBUSY) {
+			return fmt.Errorf("%w: unable to enable %s controller in %s, which contains processes, use a cgroup without processes as Cgroup.Parent or set Cgroup.MoveCaller: %w", ErrCgroupUnavailable, controller, parent, err)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: unable to enable %s controller in %s: %w", ErrCgroupUnavailable, controller, parent, err)
+		}
+	}
+	return nil
+}
+
+// ownParent returns the cgroup of the calling process, for use as the parent
+// of the helpers' cgroups, with the given controllers enabled. Controllers
+// can't be enabled for the children of a cgroup that contains processes, so if
+// that fails and moveCaller allows it, the calling process first moves to a
+// child cgroup of its own, named callerCgroup. Other processes in the cgroup
+// are left alone.
+func ownParent(controllers []string, moveCaller bool) (string, error) {
+	ownParentMu.Lock()
+	defer ownParentMu.Unlock()
+	if ownParentDir != "" {
+		// We may have moved already
+		return ownParentDir, enableControllers(ownParentDir, controllers)
+	}
+	parent, err := ownCgroup()
+	if err != nil {
+		return "", err
+	}
+	err = enableControllers(parent, controllers)
+	if !errors.Is(err, syscall.EBUSY) || !moveCaller {
+		if err == nil {
+			ownParentDir = parent
+		}
+		return parent, err
+	}
+	leaf := filepath.Join(parent, callerCgroup)
+	if err := os.Mkdir(leaf, 0755); err != nil && !errors.Is(err, os.ErrExist) {
+		return "", fmt.Errorf("%w: %w", ErrCgroupUnavailable, err)
+	}
+	err = os.WriteFile(filepath.Join(leaf, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0)
+	if err != nil {
+		return "", fmt.Errorf("%w: unable to move to %s: %w", ErrCgroupUnavailable, leaf, err)
+	}
+	ownParentDir = parent
+	return parent, enableControllers(parent, controllers)
+}
+
+// kernelVersion parses the major and minor version from a kernel release such
+// as "5.15.0-91-generic". It returns zeros if release can't be parsed.
+func kernelVersion(release string) (major, minor int) {
+	fields := strings.SplitN(release, ".", 3)
+	if len(fields) < 2 {
+		return 0, 0
+	}
+	major, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, 0
+	}
+	// The minor version may be followed by a suffix such as "-rc1"
+	digits := strings.IndexFunc(fields[1], func(r rune) bool { return r < '0' || r > '9' })
+	if digits < 0 {
+		digits = len(fields[1])
+	}
+	minor, err = strconv.Atoi(fields[1][:digits])
+	if err != nil {
+		return 0, 0
+	}
+	return major, minor
+}
+
+// ownCgroup returns the directory of the cgroup v2 of the calling process.
+func ownCgroup() (string, error) {
+	mountPoint, mountRoot, err := cgroup2Mount()
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrCgroupUnavailable, err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, found := strings.CutPrefix(line, "0::"); found {
+			path = strings.TrimPrefix(path, mountRoot)
+			return filepath.Join(mountPoint, path), nil
+		}
+	}
+	return "", fmt.Errorf("%w: process isn't in a cgroup v2", ErrCgroupUnavailable)
+}
+
+// cgroup2Mount finds where cgroup v2 is mounted, along with the path of the
+// mounted cgroup within the hierarchy.
+func cgroup2Mount() (mountPoint, root string, err error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %w", ErrCgroupUnavailable, err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// The filesystem type follows the separator after the optional fields
+		fields := strings.Fields(scanner.Text())
+		for i := 6; i < len(fields)-1; i++ {
+			if fields[i] == "-" {
+				if fields[i+1] == "cgroup2" {
+					return fields[4], fields[3], nil
+				}
+				break
+			}
+		}
+	}
+	return "", "", fmt.Errorf("%w: cgroup v2 is not mounted", ErrCgroupUnavailable)
+}
+
+// readKeyedFile reads a cgroup file consisting of lines with a key and a value.
+func readKeyedFile(path string) (map[string]int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]int64)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			values[fields[0]], _ = strconv.ParseInt(fields[1], 10, 64)
+		}
+	}
+	return values, nil
+}
+
+func hasField(s, field string) bool {
+	for _, f := range strings.Fields(s) {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+//go:build !linux
+// +build !linux
+
+package byteexec
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// Only Linux has cgroups.
+type cgroup struct{}
+
+func (be *Exec) prepareCgroup(cmd *exec.Cmd) (*cgroup, error) {
+	spec := be.opts.Cgroup
+	if spec == nil {
+		return nil, nil
+	}
+	err := fmt.Errorf("%w: %w", ErrCgroupUnavailable, errors.ErrUnsupported)
+	if spec.Required {
+		return nil, err
+	}
+	be.warnNoCgroup(err)
+	return nil, nil
+}
+
+func (cg *cgroup) started(pid int) error {
+	return nil
+}
+
+func (cg *cgroup) usage() cgroupUsage {
+	return cgroupUsage{}
+}
+
+func (cg *cgroup) remove() error {
+	return nil
+}
@@ -0,0 +1,66 @@
+//go:build linux
+// +build linux
+
+package byteexec
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCgroupUnavailable(t *testing.T) {
+	notCgroup := t.TempDir()
+	be := testHelper(t, Options{Cgroup: &Cgroup{Parent: notCgroup, MemoryMax: 64 << 20, Required: true}})
+	_, err := be.Run(context.Background(), RunSpec{Args: []string{"exit", "0"}})
+	assert.ErrorIs(t, err, ErrCgroupUnavailable)
+
+	be = testHelper(t, Options{Cgroup: &Cgroup{Parent: notCgroup, MemoryMax: 64 << 20}})
+	result, err := be.Run(context.Background(), RunSpec{Args: []string{"exit", "0"}})
+	if assert.NoError(t, err, "helper should run without cgroup") {
+		assert.Zero(t, result.MemoryPeak)
+	}
+}
+
+func TestCgroup(t *testing.T) {
+	spec := &Cgroup{MemoryMax: 64 << 20, SwapMax: NoSwap, PidsMax: 64, Required: true}
+	be := testHelper(t, Options{Cgroup: spec})
+	if cg, err := be.newCgroup(spec); errors.Is(err, ErrCgroupUnavailable) {
+		t.Skipf("No usable cgroup: %v", err)
+	} else if assert.NoError(t, err) {
+		cg.started(0)
+		assert.NoError(t, cg.remove())
+	}
+
+	ctx := context.Background()
+	result, err := be.Run(ctx, RunSpec{Args: []string{"alloc", "16"}})
+	if assert.NoError(t, err) {
+		assert.Greater(t, result.MemoryPeak, int64(16<<20))
+		assert.Zero(t, result.OOMKills)
+	}
+
+	result, err = be.Run(ctx, RunSpec{Args: []string{"alloc", "256"}})
+	if assert.Error(t, err) {
+		assert.Equal(t, syscall.SIGKILL, result.Signal)
+		assert.Equal(t, 1, result.OOMKills)
+	}
+}
+
+func TestKernelVersion(t *testing.T) {
+	for release, expected := range map[string][2]int{
+		"5.4.0-150-generic":   {5, 4},
+		"5.15.0-91-generic":   {5, 15},
+		"6.1.55-cos":          {6, 1},
+		"4.19.112+":           {4, 19},
+		"5.7-rc1":             {5, 7},
+		"6.8.12-custom-build": {6, 8},
+		"not a kernel":        {0, 0},
+		"5":                   {0, 0},
+	} {
+		major, minor := kernelVersion(release)
+		assert.Equal(t, expected, [2]int{major, minor}, release)
+	}
+}
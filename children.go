@@ -0,0 +1,174 @@
+package byteexec
+
+import (
+	"context"
+	"os/exec"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Children created with Options.KillWithParent are registered in children
+// once they start, so that Shutdown can cancel their contexts, which makes
+// os/exec kill the ones that are still running.
+var (
+	childrenMu sync.Mutex
+	children   = make(map[*child]struct{})
+)
+
+// Commands created with Options.KillWithParent are recorded in commands by
+// their SysProcAttr, which the exec.Cmd refers to but not the other way round,
+// so that Start can release the registration as soon as the command has been
+// waited for without keeping the command alive.
+var commands = make(map[*syscall.SysProcAttr]*child)
+
+// child is the registration of a started child.
+type child struct {
+	done chan struct{}
+	attr *syscall.SysProcAttr
+	// shutdown, released and stop are protected by childrenMu. stop stops
+	// watching the context the child was created with.
+	shutdown bool
+	released bool
+	stop     func() bool
+}
+
+// Shutdown kills the process groups of all running children that were created
+// by an Exec with Options.KillWithParent. Children created after Shutdown
+// returns are unaffected.
+func Shutdown() {
+	childrenMu.Lock()
+	registered := children
+	children = make(map[*child]struct{})
+	var stops []func() bool
+	for c := range registered {
+		c.shutdown = true
+		stops = append(stops, c.stop)
+	}
+	childrenMu.Unlock()
+	for c := range registered {
+		close(c.done)
+	}
+	for _, stop := range stops {
+		stop()
+	}
+}
+
+// cancel closes the child's done channel and removes the registration, unless
+// Shutdown already did.
+func (c *child) cancel() {
+	childrenMu.Lock()
+	_, found := children[c]
+	delete(children, c)
+	childrenMu.Unlock()
+	if found {
+		close(c.done)
+	}
+}
+
+// release removes the registration without closing the done channel and
+// makes sure the child isn't registered later.
+func (c *child) release() {
+	childrenMu.Lock()
+	delete(children, c)
+	if c.attr != nil {
+		delete(commands, c.attr)
+	}
+	c.released = true
+	stop := c.stop
+	childrenMu.Unlock()
+	stop()
+}
+
+// releaseChild releases the registration of the child started by cmd, if
+// any. Start calls it once the command has been waited for.
+func releaseChild(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		return
+	}
+	childrenMu.Lock()
+	c := commands[cmd.SysProcAttr]
+	childrenMu.Unlock()
+	if c != nil {
+		c.release()
+	}
+}
+
+// childContext is the context of an exec.Cmd for a child created with
+// Options.KillWithParent. It's done when either the parent context (which may
+// be nil) is done or Shutdown is called. The child is only registered once
+// os/exec asks for the Done channel, which it does when the command starts, so
+// commands that are never started cost nothing. Start releases the
+// registration once the command has been waited for. Commands run without
+// Start are released when they are no longer referenced, which is usually soon
+// after they have been waited for.
+type childContext struct {
+	parent context.Context
+	once   sync.Once
+	child  *child
+}
+
+func newChildContext(parent context.Context) *childContext {
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx := &childContext{
+		parent: parent,
+		child:  &child{done: make(chan struct{}), stop: func() bool { return false }},
+	}
+	// Only refer to the child, so that the childContext can be collected
+	c := ctx.child
+	runtime.SetFinalizer(ctx, func(*childContext) { c.release() })
+	return ctx
+}
+
+// bind records the command that ctx belongs to, so that releaseChild finds
+// it. cmd.SysProcAttr must be set.
+func (ctx *childContext) bind(cmd *exec.Cmd) {
+	childrenMu.Lock()
+	ctx.child.attr = cmd.SysProcAttr
+	commands[cmd.SysProcAttr] = ctx.child
+	childrenMu.Unlock()
+}
+
+func (ctx *childContext) Done() <-chan struct{} {
+	ctx.once.Do(func() {
+		c := ctx.child
+		childrenMu.Lock()
+		if c.released {
+			childrenMu.Unlock()
+			return
+		}
+		children[c] = struct{}{}
+		childrenMu.Unlock()
+		stop := context.AfterFunc(ctx.parent, c.cancel)
+		childrenMu.Lock()
+		c.stop = stop
+		childrenMu.Unlock()
+	})
+	return ctx.child.done
+}
+
+func (ctx *childContext) Err() error {
+	select {
+	case <-ctx.Done():
+	default:
+		return nil
+	}
+	childrenMu.Lock()
+	shutdown := ctx.child.shutdown
+	childrenMu.Unlock()
+	if shutdown {
+		return context.Canceled
+	}
+	return ctx.parent.Err()
+}
+
+func (ctx *childContext) Deadline() (time.Time, bool) {
+	return ctx.parent.Deadline()
+}
+
+func (ctx *childContext) Value(key any) any {
+	return ctx.parent.Value(key)
+}
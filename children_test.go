@@ -0,0 +1,117 @@
+//go:build !windows
+// +build !windows
+
+package byteexec
+
+import (
+	"bufio"
+	"context"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKillWithParent(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Parent death signals are only supported on Linux")
+	}
+	helperPid := startAndKillIntermediate(t, testHelper(t, Options{}))
+	assert.True(t, eventually(func() bool { return processGone(helperPid) }, 2*time.Second), "Helper should have died with the intermediate")
+}
+
+// startAndKillIntermediate starts the helper in intermediate mode with the
+// given args, kills it once it has started its own child and returns the pid of
+// that child.
+func startAndKillIntermediate(t *testing.T, be *Exec, args ...string) int {
+	cmd := be.Command(append([]string{"intermediate"}, args...)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Unable to start intermediate: %v", err)
+	}
+	out := bufio.NewReader(stdout)
+	line, err := out.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Unable to read helper pid: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		t.Fatalf("Unable to parse helper pid %q: %v", line, err)
+	}
+	waitForLine(t, out, "ready")
+
+	cmd.Process.Kill()
+	cmd.Wait()
+	return pid
+}
+
+func TestShutdown(t *testing.T) {
+	be := testHelper(t, Options{KillWithParent: true})
+	cmd := be.Command("sleep")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Unable to start helper: %v", err)
+	}
+	waitForLine(t, bufio.NewReader(stdout), "ready")
+
+	Shutdown()
+	err = cmd.Wait()
+	if assert.Error(t, err, "Helper should have been killed") {
+		status := cmd.ProcessState.Sys().(syscall.WaitStatus)
+		assert.Equal(t, syscall.SIGKILL, status.Signal())
+	}
+
+	// Children created after Shutdown should work normally
+	cmd = be.Command("sleep")
+	stdout, err = cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Unable to start helper after Shutdown: %v", err)
+	}
+	waitForLine(t, bufio.NewReader(stdout), "ready")
+	cmd.Process.Kill()
+	cmd.Wait()
+}
+
+func TestChildrenReleased(t *testing.T) {
+	be := testHelper(t, Options{KillWithParent: true})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	childrenMu.Lock()
+	before := len(children)
+	childrenMu.Unlock()
+	for i := 0; i < 10; i++ {
+		p, err := be.Start(ctx, be.CommandContext(ctx, "exit", "0"))
+		if assert.NoError(t, err) {
+			p.Wait()
+		}
+	}
+	childrenMu.Lock()
+	after := len(children)
+	childrenMu.Unlock()
+	assert.Equal(t, before, after, "Children should be released once waited for")
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, be.CommandContext(ctx, "exit", "0").Run())
+	}
+	// Commands that are never started aren't registered at all
+	be.CommandContext(ctx, "exit", "0")
+	assert.True(t, eventually(func() bool {
+		runtime.GC()
+		childrenMu.Lock()
+		defer childrenMu.Unlock()
+		return len(children) == 0 && len(commands) == 0
+	}, 2*time.Second), "Children run without Start should be released once collected")
+}
@@ -0,0 +1,80 @@
+package byteexec
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+// DefaultEnv are the variables helpers start out with under an EnvPolicy, so
+// that their output doesn't depend on the locale and time zone of the host.
+var DefaultEnv = []string{"LANG=C", "TZ=UTC"}
+
+// EnvPolicy determines the environment of helpers. Without one, helpers
+// inherit the entire environment of the calling process, like with os/exec,
+// including any secrets in it.
+type EnvPolicy struct {
+	// Inherit lists the variables helpers inherit from the calling process.
+	// A name ending in * matches all variables with that prefix, for example
+	// "LC_*". No other variables are inherited.
+	Inherit []string
+
+	// Set are variables of the form "key=value" set for every helper. They
+	// take precedence over DefaultEnv and inherited variables.
+	Set []string
+
+	// NoDefaults leaves out DefaultEnv.
+	NoDefaults bool
+}
+
+// inherits reports whether the policy inherits the variable key.
+func (policy *EnvPolicy) inherits(key string) bool {
+	for _, pattern := range policy.Inherit {
+		if prefix, found := strings.CutSuffix(pattern, "*"); found {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		} else if key == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// Environ returns the environment helpers are started with, as a sorted list
+// of variables of the form "key=value". The overrides, in the same form, take
+// precedence over everything else. Run passes RunSpec.Env as overrides.
+func (be *Exec) Environ(overrides ...string) []string {
+	policy := be.opts.Env
+	if policy == nil {
+		return mergeEnv(os.Environ(), overrides)
+	}
+	var env []string
+	if !policy.NoDefaults {
+		env = append(env, DefaultEnv...)
+	}
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		if policy.inherits(key) {
+			env = append(env, kv)
+		}
+	}
+	env = mergeEnv(env, policy.Set)
+	return mergeEnv(env, overrides)
+}
+
+// mergeEnv returns the variables in env and overrides, sorted by key. Later
+// values of the same variable replace earlier ones.
+func mergeEnv(env, overrides []string) []string {
+	values := make(map[string]string, len(env)+len(overrides))
+	for _, kv := range append(env[:len(env):len(env)], overrides...) {
+		key, _, _ := strings.Cut(kv, "=")
+		values[key] = kv
+	}
+	merged := make([]string, 0, len(values))
+	for _, kv := range values {
+		merged = append(merged, kv)
+	}
+	sort.Strings(merged)
+	return merged
+}
@@ -0,0 +1,64 @@
+//go:build !windows
+// +build !windows
+
+package byteexec
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnviron(t *testing.T) {
+	t.Setenv("BYTEEXEC_TOKEN", "secret")
+	t.Setenv("BYTEEXEC_KEEP", "kept")
+	t.Setenv("LC_BYTEEXEC", "C.UTF-8")
+	t.Setenv("LANG", "de_DE.UTF-8")
+
+	be := testHelper(t, Options{})
+	env := be.Environ("BYTEEXEC_KEEP=overridden")
+	assert.Contains(t, env, "BYTEEXEC_TOKEN=secret", "everything should be inherited without a policy")
+	assert.Contains(t, env, "BYTEEXEC_KEEP=overridden")
+	assert.NotContains(t, env, "BYTEEXEC_KEEP=kept")
+
+	be = testHelper(t, Options{Env: &EnvPolicy{
+		Inherit: []string{"BYTEEXEC_KEEP", "LC_*"},
+		Set:     []string{"BYTEEXEC_SET=set", "TZ=Europe/Berlin"},
+	}})
+	assert.Equal(t, []string{
+		"BYTEEXEC_KEEP=kept",
+		"BYTEEXEC_SET=set",
+		"LANG=C",
+		"LC_BYTEEXEC=C.UTF-8",
+		"TZ=Europe/Berlin",
+	}, be.Environ())
+	assert.Equal(t, []string{
+		"BYTEEXEC_KEEP=kept",
+		"BYTEEXEC_SET=run",
+		"BYTEEXEC_TOKEN=run",
+		"LANG=C",
+		"LC_BYTEEXEC=C.UTF-8",
+		"TZ=Europe/Berlin",
+	}, be.Environ("BYTEEXEC_SET=run", "BYTEEXEC_TOKEN=run"))
+
+	be = testHelper(t, Options{Env: &EnvPolicy{Inherit: []string{"LANG"}, NoDefaults: true}})
+	assert.Equal(t, []string{"LANG=de_DE.UTF-8"}, be.Environ())
+}
+
+func TestEnvPolicy(t *testing.T) {
+	t.Setenv("BYTEEXEC_TOKEN", "secret")
+	be := testHelper(t, Options{Env: &EnvPolicy{Set: []string{"BYTEEXEC_SET=set"}}})
+	ctx := context.Background()
+
+	result, err := be.Run(ctx, RunSpec{Args: []string{"env"}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, strings.Join(be.Environ(), "\n")+"\n", string(result.Stdout))
+		assert.NotContains(t, string(result.Stdout), "BYTEEXEC_TOKEN")
+	}
+	result, err = be.Run(ctx, RunSpec{Args: []string{"env"}, Env: []string{"BYTEEXEC_SET=run", "TZ=Asia/Tokyo"}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "BYTEEXEC_SET=run\nLANG=C\nTZ=Asia/Tokyo\n", string(result.Stdout))
+	}
+}
@@ -0,0 +1,35 @@
+package byteexec
+
+import (
+	"errors"
+)
+
+// Errors returned by New and Existing. Each error returned by this package
+// wraps one of these along with the underlying cause, so callers can test for
+// them with errors.Is, for example to fall back to another directory when the
+// standard one is unusable:
+//
+//	be, err := byteexec.New(data, "helper")
+//	if errors.Is(err, byteexec.ErrMkdir) || errors.Is(err, byteexec.ErrHomeDir) {
+//	  be, err = byteexec.New(data, filepath.Join(os.TempDir(), "helper"))
+//	}
+var (
+	// ErrHomeDir indicates that the user's home directory could not be
+	// determined.
+	ErrHomeDir = errors.New("unable to determine user's home directory")
+
+	// ErrMkdir indicates that the folder for the executable could not be
+	// created.
+	ErrMkdir = errors.New("unable to make folder")
+
+	// ErrWrite indicates that the executable could not be written to disk.
+	ErrWrite = errors.New("unable to write executable")
+
+	// ErrIntegrity indicates that the file on disk does not contain the
+	// expected data after it was written.
+	ErrIntegrity = errors.New("executable on disk does not match expected data")
+
+	// ErrNotExecutable indicates that the file at the given path is missing,
+	// is not a regular file or does not have an executable file mode.
+	ErrNotExecutable = errors.New("file is not executable")
+)
@@ -0,0 +1,159 @@
+package byteexec
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// MaxEventSize is the longest line that Events will try to decode. Longer lines
+// are reported as malformed.
+const MaxEventSize = 1024 * 1024
+
+// ErrEventTooLarge is passed to the malformed callback of Events for lines
+// longer than MaxEventSize.
+var ErrEventTooLarge = errors.New("event too large")
+
+// Events decodes the newline-delimited JSON that a helper writes to its stdout
+// into values of type T.
+type Events[T any] struct {
+	// Process is the helper writing the events.
+	Process *Process
+
+	c           chan T
+	onMalformed func(line []byte, err error)
+	readDone    chan struct{}
+	readErr     error
+}
+
+// StartEvents starts cmd like Exec.Start with its stdout connected to a new
+// Events. cmd.Stdout must not be set. onMalformed, if not nil, is called with
+// every non-empty line that can't be decoded into a T, otherwise such lines are
+// logged and skipped.
+func StartEvents[T any](ctx context.Context, be *Exec, cmd *exec.Cmd, onMalformed func(line []byte, err error)) (*Events[T], error) {
+	if cmd.Stdout != nil {
+		return nil, errors.New("byteexec: Stdout already set")
+	}
+	// We use our own pipe instead of StdoutPipe, because the Process waits on
+	// cmd right away, which would close the pipe before we're done reading.
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = pw
+	p, err := be.Start(ctx, cmd)
+	pw.Close()
+	if err != nil {
+		pr.Close()
+		return nil, err
+	}
+
+	if onMalformed == nil {
+		onMalformed = func(line []byte, err error) {
+			be.logger().Debug("skipping malformed event", "path", be.Filename, "pid", p.Pid(), "line", string(line), "error", err)
+		}
+	}
+	e := &Events[T]{
+		Process:     p,
+		c:           make(chan T),
+		onMalformed: onMalformed,
+		readDone:    make(chan struct{}),
+	}
+	go e.read(ctx, pr)
+	return e, nil
+}
+
+// C returns the channel of decoded events. It's closed once the helper closes
+// its stdout, normally when it exits, or ctx is done.
+func (e *Events[T]) C() <-chan T {
+	return e.c
+}
+
+// Wait waits until the helper has exited and all of its events have been read.
+// It returns the error from reading the events, if any, or else the result of
+// Process.Wait.
+func (e *Events[T]) Wait() error {
+	<-e.readDone
+	err := e.Process.Wait()
+	if e.readErr != nil {
+		return e.readErr
+	}
+	return err
+}
+
+func (e *Events[T]) read(ctx context.Context, r io.ReadCloser) {
+	defer close(e.readDone)
+	defer close(e.c)
+	defer r.Close()
+
+	lines := newLineReader(r, MaxEventSize)
+	for {
+		line, tooLong, err := lines.next()
+		if err != nil {
+			if err != io.EOF {
+				e.readErr = fmt.Errorf("unable to read events: %w", err)
+			}
+			return
+		}
+		if tooLong {
+			e.onMalformed(line, ErrEventTooLarge)
+			continue
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var event T
+		if err := json.Unmarshal(line, &event); err != nil {
+			e.onMalformed(line, err)
+			continue
+		}
+		select {
+		case e.c <- event:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// lineReader reads lines of limited length.
+type lineReader struct {
+	r     *bufio.Reader
+	limit int
+}
+
+func newLineReader(r io.Reader, limit int) *lineReader {
+	return &lineReader{r: bufio.NewReader(r), limit: limit}
+}
+
+// next returns the next line without its newline. If the line is longer than
+// the limit, it returns the first limit bytes and skips the rest of it. A final
+// line without a newline is returned as is, followed by io.EOF on the next
+// call.
+func (lr *lineReader) next() (line []byte, tooLong bool, err error) {
+	for {
+		chunk, err := lr.r.ReadSlice('\n')
+		if !tooLong {
+			line = append(line, chunk...)
+			if len(line) > lr.limit {
+				line = line[:lr.limit]
+				tooLong = true
+			}
+		}
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case err == io.EOF && (len(line) > 0 || tooLong):
+			return line, tooLong, nil
+		case err != nil:
+			return nil, false, err
+		}
+		return bytes.TrimSuffix(line, []byte("\n")), tooLong, nil
+	}
+}
@@ -0,0 +1,49 @@
+//go:build !windows
+// +build !windows
+
+package byteexec
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvents(t *testing.T) {
+	type event struct {
+		N int
+	}
+	be := testHelper(t, Options{})
+	var malformed []string
+	events, err := StartEvents[event](context.Background(), be, be.Command("events"), func(line []byte, err error) {
+		malformed = append(malformed, string(line))
+	})
+	if err != nil {
+		t.Fatalf("Unable to start helper: %v", err)
+	}
+	var received []event
+	for e := range events.C() {
+		received = append(received, e)
+	}
+	assert.NoError(t, events.Wait())
+	assert.Equal(t, []event{{1}, {2}}, received)
+	assert.Equal(t, []string{"not json"}, malformed)
+}
+
+func TestLineReader(t *testing.T) {
+	lr := newLineReader(strings.NewReader("short\n"+strings.Repeat("x", 10)+"\nlast"), 8)
+	line, tooLong, err := lr.next()
+	assert.Equal(t, "short", string(line))
+	assert.False(t, tooLong)
+	assert.NoError(t, err)
+	line, tooLong, _ = lr.next()
+	assert.Equal(t, "xxxxxxxx", string(line))
+	assert.True(t, tooLong)
+	line, tooLong, _ = lr.next()
+	assert.Equal(t, "last", string(line))
+	assert.False(t, tooLong)
+	_, _, err = lr.next()
+	assert.Error(t, err)
+}
@@ -1,11 +1,12 @@
 module github.com/getlantern/byteexec
 
-go 1.18
+go 1.22
 
 require (
 	github.com/getlantern/filepersist v0.0.0-20210901195658-ed29a1cb0b7c
 	github.com/getlantern/golog v0.0.0-20211223150227-d4d95a44d873
 	github.com/stretchr/testify v1.8.0
+	golang.org/x/sys v0.28.0
 )
 
 require (
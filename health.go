@@ -0,0 +1,164 @@
+package byteexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ErrUnhealthy is the error for a run of a supervised helper that was stopped
+// because its liveness probe kept failing.
+var ErrUnhealthy = errors.New("helper failed liveness probe")
+
+// Defaults for HealthCheck.
+const (
+	DefaultProbeInterval    = time.Second
+	DefaultProbeTimeout     = time.Second
+	DefaultFailureThreshold = 3
+)
+
+// Probe checks whether a helper is healthy, returning an error if it isn't. It
+// should give up once ctx is done.
+type Probe func(ctx context.Context) error
+
+// TCPProbe succeeds when a TCP connection to addr can be established.
+func TCPProbe(addr string) Probe {
+	return dialProbe("tcp", addr)
+}
+
+// UnixProbe succeeds when a connection to the Unix socket at path can be
+// established.
+func UnixProbe(path string) Probe {
+	return dialProbe("unix", path)
+}
+
+func dialProbe(network, addr string) Probe {
+	return func(ctx context.Context) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// HTTPProbe succeeds when a GET request for url returns a 2xx or 3xx status.
+func HTTPProbe(url string) Probe {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return fmt.Errorf("unexpected status %v", resp.Status)
+		}
+		return nil
+	}
+}
+
+// HealthCheck runs a Probe periodically against a supervised helper. Zero
+// values are replaced with the corresponding defaults.
+type HealthCheck struct {
+	Probe Probe
+
+	// Interval is the time between the starts of consecutive checks.
+	Interval time.Duration
+
+	// Timeout bounds each individual check.
+	Timeout time.Duration
+
+	// FailureThreshold is the number of consecutive failed liveness checks
+	// after which the helper is restarted. It doesn't apply to readiness.
+	FailureThreshold int
+}
+
+func (hc *HealthCheck) withDefaults() *HealthCheck {
+	result := *hc
+	if result.Interval <= 0 {
+		result.Interval = DefaultProbeInterval
+	}
+	if result.Timeout <= 0 {
+		result.Timeout = DefaultProbeTimeout
+	}
+	if result.FailureThreshold <= 0 {
+		result.FailureThreshold = DefaultFailureThreshold
+	}
+	return &result
+}
+
+// check runs the probe once, bounded by the timeout.
+func (hc *HealthCheck) check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, hc.Timeout)
+	defer cancel()
+	return hc.Probe(ctx)
+}
+
+// monitor waits for p to become ready, closing ready once it is, and then
+// watches its liveness until ctx is done. If p fails its liveness probe too
+// often, monitor reports why on unhealthy and stops p. It closes done when it
+// returns.
+func (s *Supervisor) monitor(ctx context.Context, p *Process, ready chan struct{}, unhealthy chan<- error, done chan<- struct{}) {
+	defer close(done)
+	log := s.be.logger()
+	if s.opts.Readiness != nil {
+		hc := s.opts.Readiness.withDefaults()
+		for {
+			err := hc.check(ctx)
+			if err == nil {
+				break
+			}
+			log.Debug("helper not ready yet", "path", s.be.Filename, "pid", p.Pid(), "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(hc.Interval):
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		// The helper exited before it became ready
+		return
+	}
+	log.Debug("helper ready", "path", s.be.Filename, "pid", p.Pid())
+	close(ready)
+
+	if s.opts.Liveness == nil {
+		return
+	}
+	hc := s.opts.Liveness.withDefaults()
+	ticker := time.NewTicker(hc.Interval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := hc.check(ctx)
+		if err == nil {
+			failures = 0
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		failures++
+		log.Debug("helper failed liveness probe", "path", s.be.Filename, "pid", p.Pid(), "failures", failures, "error", err)
+		if failures >= hc.FailureThreshold {
+			log.Error("stopping unhealthy helper", "path", s.be.Filename, "pid", p.Pid(), "error", err)
+			unhealthy <- fmt.Errorf("%w: %d consecutive failures, last: %w", ErrUnhealthy, failures, err)
+			p.Stop()
+			return
+		}
+	}
+}
@@ -0,0 +1,80 @@
+//go:build !windows
+// +build !windows
+
+package byteexec
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadiness(t *testing.T) {
+	// Find a free port for the helper
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	be := testHelper(t, Options{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := be.Supervise(SupervisorOptions{
+		Args:      []string{"listen", addr},
+		Readiness: &HealthCheck{Probe: TCPProbe(addr), Interval: 20 * time.Millisecond},
+	})
+	go s.Run(ctx)
+
+	select {
+	case <-s.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Helper didn't become ready")
+	}
+	conn, err := net.Dial("tcp", addr)
+	if assert.NoError(t, err, "Helper should be listening once ready") {
+		conn.Close()
+	}
+}
+
+func TestLiveness(t *testing.T) {
+	be := testHelper(t, Options{StopTimeout: 100 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var healthy int32 = 1
+	var starts int32
+	exits := make(chan error, 10)
+	s := be.Supervise(SupervisorOptions{
+		Args:       []string{"term"},
+		Policy:     RestartOnFailure,
+		MinBackoff: 10 * time.Millisecond,
+		Liveness: &HealthCheck{
+			Probe: func(ctx context.Context) error {
+				if atomic.LoadInt32(&healthy) == 0 {
+					return errors.New("unhealthy")
+				}
+				return nil
+			},
+			Interval:         10 * time.Millisecond,
+			FailureThreshold: 2,
+		},
+		OnStart: func(p *Process) { atomic.AddInt32(&starts, 1) },
+		OnExit:  func(p *Process, err error) { exits <- err },
+	})
+	go s.Run(ctx)
+
+	<-s.Ready()
+	atomic.StoreInt32(&healthy, 0)
+	err := <-exits
+	assert.True(t, errors.Is(err, ErrUnhealthy), "Helper should have been stopped as unhealthy, got %v", err)
+
+	// The helper exits cleanly on SIGTERM, but should still be restarted
+	atomic.StoreInt32(&healthy, 1)
+	assert.True(t, eventually(func() bool { return atomic.LoadInt32(&starts) == 2 }, 2*time.Second), "Unhealthy helper should have been restarted")
+}
@@ -0,0 +1,352 @@
+//go:build !windows
+// +build !windows
+
+package byteexec
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// The test binary doubles as a helper program for tests that need more than
+// helloworld. testHelper materializes the test binary under helperName, and
+// TestMain recognizes that name and runs the helper instead of the tests.
+const helperName = "byteexec_test_helper"
+
+var (
+	helperDir  string
+	helperOnce sync.Once
+	helperExec *Exec
+	helperErr  error
+)
+
+func TestMain(m *testing.M) {
+	if strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe") == helperName {
+		os.Exit(runHelper(os.Args[1:]))
+	}
+	code := m.Run()
+	if helperDir != "" {
+		os.RemoveAll(helperDir)
+	}
+	os.Exit(code)
+}
+
+// testHelper returns an Exec for the helper program configured with opts.
+func testHelper(t *testing.T, opts Options) *Exec {
+	helperOnce.Do(func() {
+		var self string
+		self, helperErr = os.Executable()
+		if helperErr != nil {
+			return
+		}
+		var data []byte
+		data, helperErr = os.ReadFile(self)
+		if helperErr != nil {
+			return
+		}
+		helperDir, helperErr = os.MkdirTemp("", "byteexec_test")
+		if helperErr != nil {
+			return
+		}
+		helperExec, helperErr = New(data, filepath.Join(helperDir, helperName))
+	})
+	if helperErr != nil {
+		t.Fatalf("Unable to create test helper: %v", helperErr)
+	}
+	be, err := newExec(helperExec.Filename, opts)
+	if err != nil {
+		t.Fatalf("Unable to create test helper: %v", err)
+	}
+	return be
+}
+
+// waitForLine reads lines from r until it finds one equal to expected.
+func waitForLine(t *testing.T, r *bufio.Reader, expected string) {
+	for {
+		line, err := r.ReadString('\n')
+		if strings.TrimSpace(line) == expected {
+			return
+		}
+		if err != nil {
+			t.Fatalf("Didn't see %q from helper: %v", expected, err)
+		}
+	}
+}
+
+// runHelper runs the helper mode named by args[0] and returns the exit code.
+func runHelper(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "no helper mode given")
+		return 2
+	}
+	mode, args := args[0], args[1:]
+	switch mode {
+	case "exit":
+		// Exit with the given code after printing something to each stream
+		code, _ := strconv.Atoi(args[0])
+		fmt.Println("stdout")
+		fmt.Fprintln(os.Stderr, "stderr")
+		return code
+	case "sleep":
+		// Sleep until killed
+		fmt.Println("ready")
+		select {}
+	case "intermediate":
+		// Start a sleeping child with the given options and print its pid
+		opts := Options{KillWithParent: true}
+		if len(args) > 0 && args[0] == "orphans" {
+			opts = Options{Orphans: OrphansReport}
+		}
+		be, err := ExistingWithOptions(os.Args[0], opts)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		cmd := be.Command("sleep")
+		stdout, _ := cmd.StdoutPipe()
+		p, err := be.Start(context.Background(), cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		waitReady(stdout)
+		fmt.Println(p.Pid())
+		fmt.Println("ready")
+		select {}
+	case "listen":
+		// Listen on the given address after a delay
+		time.Sleep(200 * time.Millisecond)
+		l, err := net.Listen("tcp", args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return 1
+			}
+			conn.Close()
+		}
+	case "term":
+		// Exit cleanly on SIGTERM
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM)
+		fmt.Println("ready")
+		<-signals
+		fmt.Println("terminating")
+		return 0
+	case "ignoreterm":
+		// Ignore SIGTERM, optionally starting a child doing the same and
+		// printing its pid
+		signal.Ignore(syscall.SIGTERM)
+		if len(args) > 0 && args[0] == "spawn" {
+			cmd := exec.Command(os.Args[0], "ignoreterm")
+			stdout, _ := cmd.StdoutPipe()
+			if err := cmd.Start(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			waitReady(stdout)
+			fmt.Println(cmd.Process.Pid)
+		}
+		fmt.Println("ready")
+		select {}
+	case "spew":
+		// Write the given number of bytes to stderr and fail
+		n, _ := strconv.Atoi(args[0])
+		os.Stderr.WriteString(strings.Repeat("x", n) + "\nfailed\n")
+		return 1
+	case "events":
+		// Print some JSON events, including a malformed one
+		fmt.Println(`{"n": 1}`)
+		fmt.Println(`not json`)
+		fmt.Println()
+		fmt.Print(`{"n": 2}`)
+		return 0
+	case "cat":
+		// Copy stdin to stdout
+		io.Copy(os.Stdout, os.Stdin)
+		return 0
+	case "openfiles":
+		// Try to open the given number of files
+		n, _ := strconv.Atoi(args[0])
+		for i := 0; i < n; i++ {
+			if _, err := os.Open(os.Args[0]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		}
+		return 0
+	case "writefile":
+		// Try to write the given number of bytes to a file in the given
+		// directory
+		n, _ := strconv.Atoi(args[1])
+		if err := os.WriteFile(filepath.Join(args[0], "file"), make([]byte, n), 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	case "readfile":
+		// Print the contents of the given file
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		os.Stdout.Write(data)
+		return 0
+	case "alloc":
+		// Try to allocate the given number of megabytes
+		n, _ := strconv.Atoi(args[0])
+		data := make([]byte, n<<20)
+		for i := range data {
+			data[i] = 1
+		}
+		return 0
+	case "hang":
+		// Neither read nor write until killed
+		select {}
+	case "burncpu":
+		// Spin until killed
+		for {
+		}
+	case "dial":
+		// Connect to the given address
+		conn, err := net.Dial("tcp", args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		conn.Close()
+		return 0
+	case "spawn":
+		// Run another instance of the helper
+		if err := exec.Command(os.Args[0], "exit", "0").Run(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	case "pid":
+		// Print our pid
+		fmt.Println(os.Getpid())
+		return 0
+	case "interfaces":
+		// Print the network interfaces and their flags
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, iface := range ifaces {
+			fmt.Println(iface.Name, iface.Flags)
+		}
+		return 0
+	case "status":
+		// Print our credentials from /proc/self/status
+		status, err := os.ReadFile("/proc/self/status")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, line := range strings.Split(string(status), "\n") {
+			for _, field := range []string{"Uid:", "Gid:", "Groups:", "CapAmb:", "NoNewPrivs:"} {
+				if strings.HasPrefix(line, field) {
+					fmt.Println(strings.Join(strings.Fields(line), " "))
+				}
+			}
+		}
+		return 0
+	case "env":
+		// Print our environment
+		for _, kv := range os.Environ() {
+			fmt.Println(kv)
+		}
+		return 0
+	case "readfd":
+		// Print what can be read from the given descriptor and, if it's a
+		// file, whether it can be written
+		fd, _ := strconv.Atoi(args[0])
+		file := os.NewFile(uintptr(fd), "fd")
+		data, err := io.ReadAll(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		os.Stdout.Write(data)
+		if _, err := file.WriteAt([]byte("x"), 0); err == nil {
+			fmt.Fprintln(os.Stderr, "writable")
+		}
+		return 0
+	case "rpc":
+		// Serve RPC calls on stdio
+		err := ServeRPC(os.Stdin, os.Stdout, func(method string, params json.RawMessage) (any, error) {
+			switch method {
+			case "echo":
+				return params, nil
+			case "sleep":
+				var ms int
+				json.Unmarshal(params, &ms)
+				time.Sleep(time.Duration(ms) * time.Millisecond)
+				return ms, nil
+			case "crash":
+				os.Exit(1)
+			}
+			return nil, &RPCError{Code: RPCMethodNotFound, Message: "unknown method " + method}
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "unknown helper mode %v\n", mode)
+	return 2
+}
+
+func waitReady(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() && scanner.Text() != "ready" {
+	}
+	go io.Copy(io.Discard, r)
+}
+
+// processGone checks whether the process with the given pid has exited. Zombies
+// count as exited, since init may not reap them promptly inside containers.
+func processGone(pid int) bool {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		return syscall.Kill(pid, 0) != nil
+	}
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] == "Z"
+}
+
+// eventually polls condition until it's true or timeout elapses.
+func eventually(condition func() bool, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return condition()
+}
@@ -0,0 +1,243 @@
+package byteexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrAlreadyRunning is returned by Start when another instance of a helper
+// configured with SingleInstance is already running.
+var ErrAlreadyRunning = errors.New("helper is already running")
+
+// InstanceScope determines how widely SingleInstance applies.
+type InstanceScope int
+
+const (
+	// PerUser allows one running instance of the helper for each user.
+	PerUser InstanceScope = iota
+	// PerMachine allows one running instance of the helper on the machine.
+	PerMachine
+)
+
+// InstanceConflict determines what Start does when another instance of a
+// helper configured with SingleInstance is already running.
+type InstanceConflict int
+
+const (
+	// ConflictFail makes Start fail with ErrAlreadyRunning.
+	ConflictFail InstanceConflict = iota
+	// ConflictWait makes Start wait until the other instance has exited or
+	// the context passed to Start is done.
+	ConflictWait
+	// ConflictAttach makes Start return a Process for the running instance
+	// instead of starting a new one.
+	ConflictAttach
+)
+
+// SingleInstance makes sure that at most one instance of a helper runs at a
+// time, even across processes. Instances are identified by the base name of
+// the executable and coordinated through a lock file. PerUser locks live in
+// the user's cache directory, or next to the executable if there's none, where
+// other users can't interfere with them. PerMachine locks have to be shared by
+// all users, so they live in os.TempDir() where anyone can write to them. The
+// instance recorded in them is therefore only attached to if it runs the
+// helper, and its process group is only signalled if that can be verified.
+// The child inherits the lock as an extra file descriptor, so the lock is held
+// for as long as the child (or any of its own children that inherit it) runs,
+// even if the process that started it dies. SingleInstance isn't supported on
+// Windows.
+type SingleInstance struct {
+	Scope      InstanceScope
+	OnConflict InstanceConflict
+}
+
+// instanceLock is a held lock on the lock file for a helper.
+type instanceLock struct {
+	file *os.File
+}
+
+func (be *Exec) instanceLockName() (string, error) {
+	name := "byteexec-" + filepath.Base(be.Filename) + ".lock"
+	if be.opts.SingleInstance.Scope == PerMachine {
+		return filepath.Join(os.TempDir(), name), nil
+	}
+	dir, err := os.UserCacheDir()
+	if err == nil {
+		dir = filepath.Join(dir, "byteexec")
+	} else {
+		dir = filepath.Dir(be.Filename)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("%w %s: %w", ErrMkdir, dir, err)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// lockInstance acquires the lock for this Exec's helper. If another instance is
+// running and the conflict policy is ConflictAttach, it returns a Process for
+// that instance instead.
+func (be *Exec) lockInstance(ctx context.Context) (*instanceLock, *Process, error) {
+	log := be.logger()
+	name, err := be.instanceLockName()
+	if err != nil {
+		return nil, nil, err
+	}
+	shared := be.opts.SingleInstance.Scope == PerMachine
+	conflict := be.opts.SingleInstance.OnConflict
+	for {
+		file, err := openLockFile(name, shared)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to open lock file %s: %w", name, err)
+		}
+		locked, err := tryLock(file)
+		if err != nil {
+			file.Close()
+			return nil, nil, fmt.Errorf("unable to lock %s: %w", name, err)
+		}
+		if locked {
+			return &instanceLock{file}, nil, nil
+		}
+
+		pid, startTime, ownerErr := readInstanceOwner(file)
+		file.Close()
+		switch conflict {
+		case ConflictFail:
+			if ownerErr != nil {
+				return nil, nil, fmt.Errorf("%w: %s is locked", ErrAlreadyRunning, name)
+			}
+			return nil, nil, fmt.Errorf("%w with pid %d", ErrAlreadyRunning, pid)
+		case ConflictAttach:
+			// The owner is written right after the lock is acquired, so if
+			// it isn't there yet, we simply try again.
+			if ownerErr == nil && instanceAlive(pid, startTime) {
+				if ok, verified := be.checkInstance(pid); ok {
+					log.Debug("attaching to running instance", "path", be.Filename, "pid", pid, "verified", verified)
+					return nil, be.attach(pid, startTime, verified), nil
+				}
+				log.Debug("lock owner isn't running the helper", "path", be.Filename, "pid", pid)
+			}
+		}
+
+		log.Debug("waiting for running instance", "path", be.Filename, "lock", name)
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// openLockFile opens the lock file at name, creating it if necessary. Shared
+// lock files are made usable by all users. Symlinks aren't followed, so that
+// nobody can redirect our writes to the lock file elsewhere.
+func openLockFile(name string, shared bool) (*os.File, error) {
+	// Try without O_CREATE first, since some systems don't allow opening other
+	// users' files in world-writable directories with it.
+	file, err := os.OpenFile(name, os.O_RDWR|noFollow, 0)
+	if os.IsNotExist(err) {
+		mode := os.FileMode(0600)
+		if shared {
+			mode = 0666
+		}
+		file, err = os.OpenFile(name, os.O_RDWR|os.O_CREATE|noFollow, mode)
+		if err == nil && shared {
+			// Regardless of umask
+			err = file.Chmod(mode)
+		}
+	}
+	if err != nil {
+		if file != nil {
+			file.Close()
+		}
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err == nil && !info.Mode().IsRegular() {
+		err = fmt.Errorf("%s isn't a regular file", name)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// setOwner records the pid and start time of the instance holding the lock so
+// that other processes can attach to it.
+func (l *instanceLock) setOwner(pid int) {
+	startTime, _, _ := processInfo(pid)
+	if err := l.file.Truncate(0); err == nil {
+		l.file.WriteAt([]byte(fmt.Sprintf("%d %d\n", pid, startTime)), 0)
+	}
+}
+
+// close closes our copy of the lock file. The lock stays held for as long as
+// the child keeps its inherited copy open.
+func (l *instanceLock) close() {
+	l.file.Close()
+}
+
+func readInstanceOwner(file *os.File) (pid int, startTime uint64, err error) {
+	contents := make([]byte, 64)
+	n, err := file.ReadAt(contents, 0)
+	if n == 0 {
+		return 0, 0, fmt.Errorf("no owner recorded: %w", err)
+	}
+	_, err = fmt.Sscan(string(contents[:n]), &pid, &startTime)
+	return pid, startTime, err
+}
+
+// instanceAlive checks whether the instance with the given pid is still
+// running. Where start times are unknown, it trusts the pid.
+func instanceAlive(pid int, startTime uint64) bool {
+	if startTime == 0 {
+		return processExists(pid)
+	}
+	return isRunning(pid, startTime)
+}
+
+// checkInstance checks whether the instance with the given pid can be attached
+// to, which is the case unless it runs something other than the helper. It's
+// verified if it runs the helper and leads its own process group, like the
+// processes that Start starts. Where the executable can't be determined, such
+// as for other users' processes, it can be attached to but isn't verified.
+func (be *Exec) checkInstance(pid int) (ok, verified bool) {
+	exe, err := processExecutable(pid)
+	if err != nil {
+		return true, false
+	}
+	filename := be.Filename
+	if resolved, err := filepath.EvalSymlinks(filename); err == nil {
+		filename = resolved
+	}
+	if exe != filename {
+		return false, false
+	}
+	_, pgid, err := processInfo(pid)
+	return true, err == nil && pgid == pid
+}
+
+// attach returns a Process for a running instance that isn't our child. Since
+// we can't wait for it, we poll until it's gone. Unlike started processes, an
+// attached Process isn't stopped when the context passed to Start is done, and
+// unless it's verified, stopping it only signals the process itself.
+func (be *Exec) attach(pid int, startTime uint64, verified bool) *Process {
+	proc, _ := os.FindProcess(pid)
+	p := &Process{
+		proc:       proc,
+		be:         be,
+		done:       make(chan struct{}),
+		unverified: !verified,
+	}
+	go func() {
+		for instanceAlive(pid, startTime) {
+			time.Sleep(100 * time.Millisecond)
+		}
+		close(p.done)
+	}()
+	return p
+}
@@ -0,0 +1,126 @@
+//go:build !windows
+// +build !windows
+
+package byteexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSingleInstance(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	single := &SingleInstance{Scope: PerUser}
+	be := testHelper(t, Options{SingleInstance: single})
+	name, err := be.instanceLockName()
+	if assert.NoError(t, err) {
+		assert.Equal(t, filepath.Join(os.Getenv("XDG_CACHE_HOME"), "byteexec"), filepath.Dir(name), "Per user lock should be private")
+	}
+	first, err := be.Start(context.Background(), be.Command("sleep"))
+	if err != nil {
+		t.Fatalf("Unable to start first instance: %v", err)
+	}
+	defer first.Stop()
+
+	_, err = be.Start(context.Background(), be.Command("sleep"))
+	assert.True(t, errors.Is(err, ErrAlreadyRunning), "Second instance should have been refused, got %v", err)
+
+	single.OnConflict = ConflictAttach
+	attached, err := be.Start(context.Background(), be.Command("sleep"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, first.Pid(), attached.Pid(), "Should have attached to first instance")
+		assert.Nil(t, attached.Cmd)
+		if runtime.GOOS == "linux" {
+			assert.False(t, attached.unverified, "Instance running the helper should have been verified")
+		}
+	}
+
+	single.OnConflict = ConflictWait
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = be.Start(ctx, be.Command("sleep"))
+	assert.Equal(t, context.DeadlineExceeded, err, "Waiting for the first instance should have timed out")
+
+	started := make(chan *Process)
+	go func() {
+		p, err := be.Start(context.Background(), be.Command("sleep"))
+		assert.NoError(t, err)
+		started <- p
+	}()
+	first.Stop()
+	select {
+	case <-attached.Done():
+	case <-time.After(2 * time.Second):
+		t.Error("Attached process should have noticed that the instance exited")
+	}
+	select {
+	case second := <-started:
+		second.Stop()
+	case <-time.After(2 * time.Second):
+		t.Fatal("Second instance should have started once the first one exited")
+	}
+}
+
+func TestSingleInstanceSymlink(t *testing.T) {
+	be := testHelper(t, Options{SingleInstance: &SingleInstance{Scope: PerMachine}})
+	name, err := be.instanceLockName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	victim := filepath.Join(t.TempDir(), "victim")
+	if err := os.WriteFile(victim, []byte("precious"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(name)
+	if err := os.Symlink(victim, name); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+
+	_, err = be.Start(context.Background(), be.Command("exit", "0"))
+	assert.Error(t, err, "Planted symlink should have been refused")
+	data, _ := os.ReadFile(victim)
+	assert.Equal(t, "precious", string(data))
+}
+
+func TestSingleInstancePlantedOwner(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Executables of processes can only be determined on Linux")
+	}
+	single := &SingleInstance{Scope: PerMachine}
+	be := testHelper(t, Options{SingleInstance: single})
+	name, err := be.instanceLockName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(name)
+	defer os.Remove(name)
+	first, err := be.Start(context.Background(), be.Command("sleep"))
+	if err != nil {
+		t.Fatalf("Unable to start first instance: %v", err)
+	}
+	defer first.Stop()
+
+	// Anyone can write to PerMachine lock files, so record a process that
+	// doesn't run the helper as the owner
+	startTime, _, err := processInfo(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(fmt.Sprintf("%d %d\n", os.Getpid(), startTime)), 0); err != nil {
+		t.Fatal(err)
+	}
+	single.OnConflict = ConflictAttach
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, err = be.Start(ctx, be.Command("sleep"))
+	assert.Equal(t, context.DeadlineExceeded, err, "Planted owner shouldn't have been attached to")
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package byteexec
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// noFollow makes opening a symlink fail.
+const noFollow = syscall.O_NOFOLLOW
+
+// tryLock tries to take an exclusive lock on file without blocking, returning
+// false if someone else holds it.
+func tryLock(file *os.File) (bool, error) {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func processExists(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
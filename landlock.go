@@ -0,0 +1,55 @@
+package byteexec
+
+import (
+	"errors"
+)
+
+// ErrLandlockUnavailable indicates that a required Landlock restriction can't
+// be enforced because the kernel doesn't support Landlock.
+var ErrLandlockUnavailable = errors.New("landlock unavailable")
+
+// Landlock restricts the helper's access to the filesystem with Landlock. Once
+// restricted, the helper and its descendants can only access the paths listed
+// here and anything beneath them, except for files they already have open,
+// such as their stdin and stdout. The helper's executable is always allowed to
+// be executed. Dynamically linked helpers also need their libraries, for
+// example /lib and /usr/lib, in Execute.
+//
+// Landlock is only supported on Linux 5.13 and later. Which kinds of access
+// are restricted depends on the Landlock ABI the kernel implements, for
+// example truncating files is only restricted from Linux 6.2 on.
+type Landlock struct {
+	// ReadOnly are paths the helper may read files and list directories in.
+	ReadOnly []string
+
+	// ReadWrite are paths the helper may also write, create, rename and
+	// remove files and directories in.
+	ReadWrite []string
+
+	// Execute are paths the helper may read and execute files from.
+	Execute []string
+
+	// Required makes helpers fail to start with ErrLandlockUnavailable when
+	// the kernel doesn't support Landlock. Otherwise, they run unrestricted
+	// and the problem is logged.
+	Required bool
+}
+
+// landlockRule allows access to a path in a Landlock ruleset.
+type landlockRule struct {
+	Path   string
+	Access uint64
+}
+
+// landlockSpec is the Landlock ruleset the shim applies.
+type landlockSpec struct {
+	Handled uint64
+	Rules   []landlockRule
+}
+
+// warnNoLandlock logs that helpers run without Landlock, once per Exec.
+func (be *Exec) warnNoLandlock() {
+	be.noLandlockOnce.Do(func() {
+		be.logger().Error("starting helpers without landlock", "path", be.Filename, "error", ErrLandlockUnavailable)
+	})
+}
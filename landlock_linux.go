@@ -0,0 +1,147 @@
+package byteexec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Access rights by the Landlock ABI version that introduced them.
+const (
+	landlockAccessV1 = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR | unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG | unix.LANDLOCK_ACCESS_FS_MAKE_SOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_FIFO | unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM
+	landlockAccessV2 = landlockAccessV1 | unix.LANDLOCK_ACCESS_FS_REFER
+	landlockAccessV3 = landlockAccessV2 | unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	landlockAccessV5 = landlockAccessV3 | unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+)
+
+// Access rights granted for each kind of path.
+const (
+	landlockRead    = unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+	landlockExecute = landlockRead | unix.LANDLOCK_ACCESS_FS_EXECUTE
+	landlockWrite   = landlockRead | unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_DIR | unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SOCK | unix.LANDLOCK_ACCESS_FS_MAKE_FIFO |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM | unix.LANDLOCK_ACCESS_FS_REFER |
+		unix.LANDLOCK_ACCESS_FS_TRUNCATE | unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+
+	// landlockFile are the only access rights that apply to files rather
+	// than directories
+	landlockFile = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE |
+		unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+)
+
+var (
+	landlockOnce    sync.Once
+	landlockVersion int
+)
+
+// landlockABI returns the Landlock ABI version the kernel implements, or 0 if
+// it doesn't support Landlock.
+func landlockABI() int {
+	landlockOnce.Do(func() {
+		version, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+		if errno == 0 {
+			landlockVersion = int(version)
+		}
+	})
+	return landlockVersion
+}
+
+// landlockSpec prepares the ruleset for the helper. It returns nil if Landlock
+// isn't supported and not required.
+func (be *Exec) landlockSpec() (*landlockSpec, error) {
+	ll := be.opts.Landlock
+	abi := landlockABI()
+	if abi == 0 {
+		if ll.Required {
+			return nil, fmt.Errorf("byteexec: %w", ErrLandlockUnavailable)
+		}
+		be.warnNoLandlock()
+		return nil, nil
+	}
+	handled := uint64(landlockAccessV1)
+	switch {
+	case abi >= 5:
+		handled = landlockAccessV5
+	case abi >= 3:
+		handled = landlockAccessV3
+	case abi >= 2:
+		handled = landlockAccessV2
+	}
+
+	spec := &landlockSpec{Handled: handled}
+	add := func(paths []string, access uint64) error {
+		for _, path := range paths {
+			path, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				return fmt.Errorf("byteexec: unable to allow access to %s: %w", path, err)
+			}
+			access := access & handled
+			if !info.IsDir() {
+				access &= landlockFile
+			}
+			spec.Rules = append(spec.Rules, landlockRule{Path: path, Access: access})
+		}
+		return nil
+	}
+	if err := add([]string{be.Filename}, landlockExecute); err != nil {
+		return nil, err
+	}
+	if err := add(ll.ReadOnly, landlockRead); err != nil {
+		return nil, err
+	}
+	if err := add(ll.ReadWrite, landlockWrite); err != nil {
+		return nil, err
+	}
+	if err := add(ll.Execute, landlockExecute); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// apply restricts the calling thread with the ruleset.
+func (spec *landlockSpec) apply() error {
+	attr := unix.LandlockRulesetAttr{Access_fs: spec.Handled}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("unable to create landlock ruleset: %w", errno)
+	}
+	defer unix.Close(int(fd))
+
+	for _, rule := range spec.Rules {
+		pathFd, err := unix.Open(rule.Path, unix.O_PATH|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return fmt.Errorf("unable to open %s: %w", rule.Path, err)
+		}
+		beneath := unix.LandlockPathBeneathAttr{Allowed_access: rule.Access, Parent_fd: int32(pathFd)}
+		_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, fd, unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&beneath)), 0, 0, 0)
+		unix.Close(pathFd)
+		if errno != 0 {
+			return fmt.Errorf("unable to allow access to %s: %w", rule.Path, errno)
+		}
+	}
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return err
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0); errno != 0 {
+		return fmt.Errorf("unable to restrict filesystem access: %w", errno)
+	}
+	return nil
+}
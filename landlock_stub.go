@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package byteexec
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Only Linux has Landlock.
+func (be *Exec) landlockSpec() (*landlockSpec, error) {
+	if be.opts.Landlock.Required {
+		return nil, fmt.Errorf("byteexec: %w: %w", ErrLandlockUnavailable, errors.ErrUnsupported)
+	}
+	be.warnNoLandlock()
+	return nil, nil
+}
@@ -0,0 +1,57 @@
+//go:build linux
+// +build linux
+
+package byteexec
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLandlock(t *testing.T) {
+	if landlockABI() == 0 {
+		t.Skip("Kernel doesn't support Landlock")
+	}
+	in, out := t.TempDir(), t.TempDir()
+	input := filepath.Join(in, "input")
+	if err := os.WriteFile(input, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(t.TempDir(), "outside")
+	if err := os.WriteFile(outside, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The test binary may be dynamically linked
+	var libs []string
+	for _, dir := range []string{"/lib", "/lib64", "/usr/lib", "/usr/lib64"} {
+		if _, err := os.Stat(dir); err == nil {
+			libs = append(libs, dir)
+		}
+	}
+
+	be := testHelper(t, Options{Landlock: &Landlock{ReadOnly: []string{in}, ReadWrite: []string{out}, Execute: libs, Required: true}})
+	ctx := context.Background()
+	result, err := be.Run(ctx, RunSpec{Args: []string{"readfile", input}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "data", string(result.Stdout))
+	}
+	_, err = be.Run(ctx, RunSpec{Args: []string{"writefile", out, "10"}})
+	assert.NoError(t, err, "helper should be able to write its output")
+
+	result, err = be.Run(ctx, RunSpec{Args: []string{"readfile", outside}})
+	if assert.Error(t, err) {
+		assert.Contains(t, string(result.Stderr), "permission denied")
+	}
+	result, err = be.Run(ctx, RunSpec{Args: []string{"writefile", in, "10"}})
+	if assert.Error(t, err) {
+		assert.Contains(t, string(result.Stderr), "permission denied")
+	}
+
+	be = testHelper(t, Options{Landlock: &Landlock{ReadOnly: []string{filepath.Join(in, "nonexistent")}}})
+	_, err = be.Run(ctx, RunSpec{Args: []string{"exit", "0"}})
+	assert.ErrorContains(t, err, "nonexistent")
+}
@@ -0,0 +1,41 @@
+package byteexec
+
+import (
+	"time"
+)
+
+// NoCore can be used as Limits.CoreSize to keep helpers from dumping core.
+const NoCore = -1
+
+// Limits are resource limits for helpers. They're set with setrlimit(2) after
+// the helper is forked and before it's executed, so they apply from its first
+// instruction on and are inherited by its own children. Limits are only
+// supported on Linux. Fields left at zero keep the limits inherited from the
+// calling process. Limits can only be lowered, values above the inherited hard
+// limit are capped at it.
+type Limits struct {
+	// CPU is the CPU time after which the helper receives SIGXCPU, rounded up
+	// to whole seconds. If it keeps running, it's killed a second later.
+	CPU time.Duration
+
+	// AddressSpace is the size of the helper's virtual memory in bytes.
+	// Allocations beyond it fail.
+	AddressSpace int64
+
+	// OpenFiles is one more than the highest file descriptor the helper can
+	// open.
+	OpenFiles uint64
+
+	// Processes is the number of processes and threads that the user running
+	// the helper may have. Note that it counts all of the user's processes,
+	// not just the helper's, and that it doesn't apply to root.
+	Processes uint64
+
+	// CoreSize is the largest core file the helper may dump, in bytes. Use
+	// NoCore to prevent core dumps altogether.
+	CoreSize int64
+
+	// FileSize is the largest file the helper may write, in bytes. Writing
+	// beyond it fails with SIGXFSZ.
+	FileSize int64
+}
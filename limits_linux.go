@@ -0,0 +1,68 @@
+package byteexec
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// apply sets the limits for the current process.
+func (l *Limits) apply() error {
+	if l.CPU > 0 {
+		seconds := uint64((l.CPU + 999999999) / 1000000000)
+		// Leave a second between the soft and the hard limit, so that the
+		// helper gets SIGXCPU before it's killed
+		if err := setLimit(unix.RLIMIT_CPU, "cpu", seconds, seconds+1); err != nil {
+			return err
+		}
+	}
+	if l.AddressSpace > 0 {
+		if err := setLimit(unix.RLIMIT_AS, "address space", uint64(l.AddressSpace), uint64(l.AddressSpace)); err != nil {
+			return err
+		}
+	}
+	if l.OpenFiles > 0 {
+		if err := setLimit(unix.RLIMIT_NOFILE, "open files", l.OpenFiles, l.OpenFiles); err != nil {
+			return err
+		}
+	}
+	if l.Processes > 0 {
+		if err := setLimit(unix.RLIMIT_NPROC, "processes", l.Processes, l.Processes); err != nil {
+			return err
+		}
+	}
+	if l.CoreSize != 0 {
+		size := uint64(l.CoreSize)
+		if l.CoreSize == NoCore {
+			size = 0
+		}
+		if err := setLimit(unix.RLIMIT_CORE, "core size", size, size); err != nil {
+			return err
+		}
+	}
+	if l.FileSize > 0 {
+		if err := setLimit(unix.RLIMIT_FSIZE, "file size", uint64(l.FileSize), uint64(l.FileSize)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setLimit lowers the given resource limit, keeping the current hard limit if
+// it's lower already.
+func setLimit(resource int, name string, soft, hard uint64) error {
+	var current unix.Rlimit
+	if err := unix.Getrlimit(resource, &current); err != nil {
+		return fmt.Errorf("unable to get %s limit: %w", name, err)
+	}
+	if hard > current.Max {
+		hard = current.Max
+	}
+	if soft > hard {
+		soft = hard
+	}
+	if err := unix.Setrlimit(resource, &unix.Rlimit{Cur: soft, Max: hard}); err != nil {
+		return fmt.Errorf("unable to set %s limit: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+//go:build linux
+// +build linux
+
+package byteexec
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimits(t *testing.T) {
+	be := testHelper(t, Options{Limits: &Limits{
+		CPU:       time.Second,
+		OpenFiles: 20,
+		FileSize:  1024,
+		CoreSize:  NoCore,
+	}})
+	ctx := context.Background()
+
+	result, err := be.Run(ctx, RunSpec{Args: []string{"openfiles", "5"}})
+	assert.NoError(t, err, "helper should run within its limits")
+	result, err = be.Run(ctx, RunSpec{Args: []string{"openfiles", "50"}})
+	if assert.Error(t, err) {
+		assert.Contains(t, string(result.Stderr), "too many open files")
+	}
+
+	result, err = be.Run(ctx, RunSpec{Args: []string{"writefile", t.TempDir(), "4096"}})
+	if assert.Error(t, err) {
+		// The Go runtime ignores SIGXFSZ, so the write fails instead
+		assert.Contains(t, string(result.Stderr), "file too large")
+	}
+
+	result, err = be.Run(ctx, RunSpec{Args: []string{"burncpu"}, Timeout: 10 * time.Second})
+	if assert.Error(t, err) {
+		// The Go runtime ignores SIGXCPU, so the helper is killed at the hard
+		// limit
+		assert.False(t, result.TimedOut)
+		assert.Equal(t, syscall.SIGKILL, result.Signal)
+	}
+}
+
+func TestAddressSpaceLimit(t *testing.T) {
+	if raceEnabled {
+		t.Skip("The race detector needs more address space")
+	}
+	be := testHelper(t, Options{Limits: &Limits{AddressSpace: 1 << 30}})
+	result, err := be.Run(context.Background(), RunSpec{Args: []string{"alloc", "2048"}})
+	if assert.Error(t, err) {
+		assert.Contains(t, string(result.Stderr), "out of memory")
+	}
+}
+
+func TestShimSetupErrors(t *testing.T) {
+	be := &Exec{Filename: "/nonexistent/helper", opts: Options{Limits: &Limits{CoreSize: NoCore}}}
+	_, err := be.Start(context.Background(), be.Command())
+	if assert.Error(t, err, "shim failures should fail Start") {
+		assert.Contains(t, err.Error(), "unable to execute /nonexistent/helper")
+	}
+	_, err = be.Run(context.Background(), RunSpec{})
+	assert.Error(t, err, "shim failures should fail Run")
+}
+
+func TestShimEnvironment(t *testing.T) {
+	be := testHelper(t, Options{Limits: &Limits{CoreSize: NoCore}})
+	result, err := be.Run(context.Background(), RunSpec{Args: []string{"env"}, Env: []string{"FOO=bar"}})
+	if assert.NoError(t, err) {
+		stdout := string(result.Stdout)
+		assert.Contains(t, stdout, "FOO=bar")
+		assert.NotContains(t, stdout, shimEnv, "the helper shouldn't see the shim's variables")
+	}
+}
+
+func TestShimWithoutStart(t *testing.T) {
+	be := testHelper(t, Options{Limits: &Limits{CoreSize: NoCore}})
+	before := openFiles(t)
+	out, err := be.Command("env").Output()
+	if assert.NoError(t, err, "commands should also work when run directly") {
+		assert.NotContains(t, string(out), shimEnv)
+	}
+	for i := 0; i < 10; i++ {
+		be.Command("exit", "0")
+	}
+	assert.Equal(t, before, openFiles(t), "commands shouldn't hold descriptors")
+}
+
+// openFiles returns the number of descriptors this process has open.
+func openFiles(t *testing.T) int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
@@ -0,0 +1,133 @@
+package byteexec
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// maxLogLine is the longest line of helper output that's logged as one
+// message. Longer lines are split into several messages marked as partial.
+const maxLogLine = 4096
+
+// lineLogger is an io.Writer that logs every line written to it.
+type lineLogger struct {
+	log    Logger
+	name   string
+	stream string
+
+	// started is closed once the pid is known or the process failed to start
+	started chan struct{}
+	pid     int
+
+	mu  sync.Mutex
+	buf []byte
+
+	// file is our duplicate of the file the stream also goes to, if any.
+	file *os.File
+}
+
+func newLineLogger(log Logger, name, stream string) *lineLogger {
+	return &lineLogger{log: log, name: name, stream: stream, started: make(chan struct{})}
+}
+
+// start records the pid of the process writing to l.
+func (l *lineLogger) start(pid int) {
+	l.pid = pid
+	close(l.started)
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	<-l.started
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		switch {
+		case i >= 0 && i <= maxLogLine:
+			l.logLine(l.buf[:i], false)
+			l.buf = l.buf[i+1:]
+		case len(l.buf) >= maxLogLine:
+			l.logLine(l.buf[:maxLogLine], true)
+			l.buf = l.buf[maxLogLine:]
+		default:
+			// Don't let the buffer keep growing its underlying array
+			l.buf = append([]byte(nil), l.buf...)
+			return len(p), nil
+		}
+	}
+}
+
+// close logs whatever is left after the last newline and closes our
+// duplicate of the file the stream also goes to, if any. It's called once the
+// process has exited or failed to start.
+func (l *lineLogger) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.buf) > 0 {
+		l.logLine(l.buf, false)
+		l.buf = nil
+	}
+	if l.file != nil {
+		l.file.Close()
+	}
+}
+
+func (l *lineLogger) logLine(line []byte, partial bool) {
+	keyvals := []any{"helper", l.name, "stream", l.stream, "pid", l.pid, "line", strings.TrimSuffix(string(line), "\r")}
+	if partial {
+		keyvals = append(keyvals, "partial", true)
+	}
+	l.log.Info("helper output", keyvals...)
+}
+
+// helperName returns the name under which the helper's output is logged.
+func (be *Exec) helperName() string {
+	if be.opts.Name != "" {
+		return be.opts.Name
+	}
+	return strings.TrimSuffix(filepath.Base(be.Filename), filepath.Ext(be.Filename))
+}
+
+// logOutput makes cmd's stdout and stderr go to the logger, in addition to
+// wherever they already go. Streams that go to a file, including pipes from
+// StdoutPipe and StderrPipe, are copied to a duplicate of the file, which
+// stays open when os/exec closes its own copy after starting the process.
+func (be *Exec) logOutput(cmd *exec.Cmd) ([]*lineLogger, error) {
+	var loggers []*lineLogger
+	tee := func(w io.Writer, stream string) (io.Writer, error) {
+		l := newLineLogger(be.logger(), be.helperName(), stream)
+		if f, isFile := w.(*os.File); isFile {
+			dup, err := dupFile(f)
+			if err != nil {
+				return nil, fmt.Errorf("byteexec: unable to log %s: %w", stream, err)
+			}
+			l.file = dup
+			w = dup
+		}
+		loggers = append(loggers, l)
+		if w == nil {
+			return l, nil
+		}
+		return io.MultiWriter(w, l), nil
+	}
+	stdout, err := tee(cmd.Stdout, "stdout")
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := tee(cmd.Stderr, "stderr")
+	if err != nil {
+		for _, l := range loggers {
+			l.close()
+		}
+		return nil, err
+	}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	return loggers, nil
+}
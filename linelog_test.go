@@ -0,0 +1,120 @@
+//go:build !windows
+// +build !windows
+
+package byteexec
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// syncBuffer is a bytes.Buffer that's safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func TestLogOutput(t *testing.T) {
+	var buf syncBuffer
+	log := Slog(slog.New(slog.NewJSONHandler(&buf, nil)))
+	be := testHelper(t, Options{Logger: log, Name: "test", LogOutput: true})
+	input := "first\r\n" + strings.Repeat("y", maxLogLine+10) + "\nunterminated"
+	result, err := be.Run(context.Background(), RunSpec{Args: []string{"cat"}, Stdin: strings.NewReader(input)})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, input, string(result.Stdout), "Output should still be captured")
+
+	type entry struct {
+		Msg     string
+		Helper  string
+		Stream  string
+		Pid     int
+		Line    string
+		Partial bool
+	}
+	var entries []entry
+	dec := json.NewDecoder(&buf.buf)
+	for dec.More() {
+		var e entry
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Msg == "helper output" {
+			assert.Equal(t, "test", e.Helper)
+			assert.Equal(t, "stdout", e.Stream)
+			assert.NotZero(t, e.Pid)
+			entries = append(entries, e)
+		}
+	}
+	if assert.Len(t, entries, 4) {
+		assert.Equal(t, "first", entries[0].Line)
+		assert.Equal(t, strings.Repeat("y", maxLogLine), entries[1].Line)
+		assert.True(t, entries[1].Partial)
+		assert.Equal(t, strings.Repeat("y", 10), entries[2].Line)
+		assert.False(t, entries[2].Partial)
+		assert.Equal(t, "unterminated", entries[3].Line)
+	}
+}
+
+func TestLogOutputFiles(t *testing.T) {
+	var buf syncBuffer
+	log := Slog(slog.New(slog.NewJSONHandler(&buf, nil)))
+	be := testHelper(t, Options{Logger: log, LogOutput: true})
+	file, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	cmd := be.Command("exit", "0")
+	cmd.Stdout = file
+	p, err := be.Start(context.Background(), cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, p.Wait())
+	written, _ := os.ReadFile(file.Name())
+	assert.Equal(t, "stdout\n", string(written), "Output should still go to the file")
+
+	// Pipes are closed by os/exec once the process has started
+	cmd = be.Command("cat")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = be.Start(context.Background(), cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(stdin, "piped\n")
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "piped\n", line, "Output should still go to the pipe")
+	stdin.Close()
+	assert.NoError(t, p.Wait())
+
+	logged := buf.buf.String()
+	assert.Contains(t, logged, `"line":"stdout"`)
+	assert.Contains(t, logged, `"line":"piped"`)
+}
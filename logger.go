@@ -0,0 +1,92 @@
+package byteexec
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/getlantern/golog"
+)
+
+var (
+	defaultLogger = Golog(golog.LoggerFor("Exec"))
+)
+
+// Logger is the interface through which byteexec reports what it's doing.
+// Messages carry structured fields as alternating key/value pairs, the same way
+// as log/slog, so a *slog.Logger can be used as a Logger directly.
+type Logger interface {
+	Debug(msg string, keyvals ...any)
+	Info(msg string, keyvals ...any)
+	Error(msg string, keyvals ...any)
+}
+
+// Slog returns a Logger that writes to l. If l is nil, slog.Default() is used.
+func Slog(l *slog.Logger) Logger {
+	if l == nil {
+		return slog.Default()
+	}
+	return l
+}
+
+// Golog returns a Logger that writes to l. Since golog doesn't support
+// structured fields, they are appended to the message in key=value form. Debug
+// messages are logged at golog's trace level, Info messages at its debug level.
+func Golog(l golog.Logger) Logger {
+	return &gologLogger{l}
+}
+
+type gologLogger struct {
+	log golog.Logger
+}
+
+func (l *gologLogger) Debug(msg string, keyvals ...any) {
+	if l.log.IsTraceEnabled() {
+		l.log.Trace(formatKeyvals(msg, keyvals))
+	}
+}
+
+func (l *gologLogger) Info(msg string, keyvals ...any) {
+	l.log.Debug(formatKeyvals(msg, keyvals))
+}
+
+func (l *gologLogger) Error(msg string, keyvals ...any) {
+	l.log.Error(formatKeyvals(msg, keyvals))
+}
+
+// formatKeyvals renders msg followed by keyvals in logfmt style.
+func formatKeyvals(msg string, keyvals []any) string {
+	var sb strings.Builder
+	sb.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		sb.WriteByte(' ')
+		if i+1 == len(keyvals) {
+			// Same as slog, a dangling value is reported without a key
+			sb.WriteString("!BADKEY=")
+			sb.WriteString(formatValue(keyvals[i]))
+			break
+		}
+		sb.WriteString(fmt.Sprint(keyvals[i]))
+		sb.WriteByte('=')
+		sb.WriteString(formatValue(keyvals[i+1]))
+	}
+	return sb.String()
+}
+
+func formatValue(value any) string {
+	s := fmt.Sprint(value)
+	if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// logger returns the Logger for be, falling back to the default for Execs that
+// weren't created through New or Existing.
+func (be *Exec) logger() Logger {
+	if be.opts.Logger == nil {
+		return defaultLogger
+	}
+	return be.opts.Logger
+}
@@ -0,0 +1,50 @@
+package byteexec
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlogLogger(t *testing.T) {
+	data, err := Asset(program)
+	if err != nil {
+		t.Fatalf("Unable to read helloworld program: %s", err)
+	}
+	filename := filepath.Join(t.TempDir(), program)
+
+	var buf bytes.Buffer
+	opts := Options{Logger: Slog(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))}
+	for i := 0; i < 2; i++ {
+		if _, err := NewWithOptions(data, filename, opts); err != nil {
+			t.Fatalf("Unable to create new ByteExec: %s", err)
+		}
+	}
+
+	var saved []map[string]interface{}
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var entry map[string]interface{}
+		if err := dec.Decode(&entry); err != nil {
+			t.Fatalf("Unable to decode log entry: %v", err)
+		}
+		if entry["msg"] == "saved executable" {
+			saved = append(saved, entry)
+		}
+	}
+	if assert.Len(t, saved, 2) {
+		assert.Equal(t, renameExecutable(filename), saved[0]["path"])
+		assert.EqualValues(t, len(data), saved[0]["bytes_written"])
+		assert.Equal(t, false, saved[0]["reused"])
+		assert.EqualValues(t, 0, saved[1]["bytes_written"])
+		assert.Equal(t, true, saved[1]["reused"], "Second New should reuse the existing file")
+	}
+}
+
+func TestFormatKeyvals(t *testing.T) {
+	assert.Equal(t, `saved path="/a b" bytes=3 !BADKEY=x`, formatKeyvals("saved", []any{"path", "/a b", "bytes", 3, "x"}))
+}
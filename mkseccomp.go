@@ -0,0 +1,95 @@
+//go:build ignore
+// +build ignore
+
+// mkseccomp generates the tables of syscall numbers used by seccomp filters
+// from the zsysnum files of golang.org/x/sys/unix, so that they always match
+// the version of golang.org/x/sys in go.mod.
+//
+// Usage:
+//
+//	go run mkseccomp.go <arch>
+//
+// writes seccomp_linux_<arch>.go.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// auditArchs are the names of the unix constants for the architectures
+// reported to seccomp filters.
+var auditArchs = map[string]string{
+	"amd64": "AUDIT_ARCH_X86_64",
+	"arm64": "AUDIT_ARCH_AARCH64",
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("mkseccomp: ")
+	if len(os.Args) != 2 {
+		log.Fatal("usage: go run mkseccomp.go <arch>")
+	}
+	arch := os.Args[1]
+	auditArch, found := auditArchs[arch]
+	if !found {
+		log.Fatalf("unsupported architecture %s", arch)
+	}
+
+	out, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", "golang.org/x/sys").Output()
+	if err != nil {
+		log.Fatalf("unable to find golang.org/x/sys: %v", err)
+	}
+	sysnum := filepath.Join(strings.TrimSpace(string(out)), "unix", "zsysnum_linux_"+arch+".go")
+	file, err := parser.ParseFile(token.NewFileSet(), sysnum, nil, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by mkseccomp.go %s; DO NOT EDIT.\n\n", arch)
+	fmt.Fprintf(&buf, "package byteexec\n\n")
+	fmt.Fprintf(&buf, "import (\n\t\"golang.org/x/sys/unix\"\n)\n\n")
+	fmt.Fprintf(&buf, "// auditArch is the architecture reported to seccomp filters.\n")
+	fmt.Fprintf(&buf, "const auditArch = unix.%s\n\n", auditArch)
+	fmt.Fprintf(&buf, "// syscallNumbers maps the names of syscalls to their numbers.\n")
+	fmt.Fprintf(&buf, "var syscallNumbers = map[string]uint32{\n")
+	count := 0
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			for _, ident := range spec.(*ast.ValueSpec).Names {
+				name, found := strings.CutPrefix(ident.Name, "SYS_")
+				if !found {
+					continue
+				}
+				fmt.Fprintf(&buf, "\t%q: unix.%s,\n", strings.ToLower(name), ident.Name)
+				count++
+			}
+		}
+	}
+	fmt.Fprintf(&buf, "}\n")
+	if count == 0 {
+		log.Fatalf("no syscalls found in %s", sysnum)
+	}
+
+	source, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("seccomp_linux_"+arch+".go", source, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,36 @@
+package byteexec
+
+// Namespaces isolates helpers in Linux namespaces of their own. Namespaces are
+// only supported on Linux, and creating them requires either root or
+// unprivileged user namespaces, see User. Use CheckNamespaces to find out
+// whether they're available.
+type Namespaces struct {
+	// Network gives the helper a network namespace of its own, with nothing
+	// but a loopback interface. The helper can't reach any other network,
+	// including the host's loopback.
+	Network bool
+
+	// Mount gives the helper a private mount namespace in which the root
+	// filesystem and all filesystems mounted beneath it, such as /dev/shm,
+	// are read-only, and /tmp is an empty tmpfs, except for the helper's
+	// executable. Device files such as /dev/null can still be written.
+	Mount bool
+
+	// Writable lists paths that stay writable in the helper's mount
+	// namespace, even if they're beneath /tmp. It only applies with Mount.
+	Writable []string
+
+	// PID gives the helper a PID namespace of its own, in which it is pid 1
+	// and can't see other processes. With Mount, /proc is remounted to match
+	// the new namespace where the kernel allows it. As pid 1, the helper
+	// ignores signals it has no handler for, so unless it handles the stop
+	// signal, Process.Stop kills it right away.
+	PID bool
+
+	// User creates the other namespaces within a new user namespace, in
+	// which the calling user and group are mapped to themselves. This
+	// allows unprivileged processes to create namespaces, if the kernel
+	// permits unprivileged user namespaces. The helper itself runs without
+	// any capabilities in the namespace.
+	User bool
+}
@@ -0,0 +1,318 @@
+package byteexec
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// resolveNamespaces returns a copy of ns with absolute paths, for the shim.
+func resolveNamespaces(ns *Namespaces) (*Namespaces, error) {
+	resolved := *ns
+	resolved.Writable = make([]string, len(ns.Writable))
+	for i, path := range ns.Writable {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		resolved.Writable[i] = path
+	}
+	return &resolved, nil
+}
+
+// setNamespaces makes cmd start in new namespaces. The shim sets up their
+// contents.
+func setNamespaces(cmd *exec.Cmd, ns *Namespaces) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	attr := cmd.SysProcAttr
+	if ns.Network {
+		attr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	if ns.Mount {
+		attr.Cloneflags |= syscall.CLONE_NEWNS
+	}
+	if ns.PID {
+		attr.Cloneflags |= syscall.CLONE_NEWPID
+	}
+	if ns.User {
+		attr.Cloneflags |= syscall.CLONE_NEWUSER
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}}
+		attr.GidMappingsEnableSetgroups = false
+		// Unless mapped to root, the shim would lose its capabilities in the
+		// namespace when it's executed. It drops them before executing the
+		// helper.
+		attr.AmbientCaps = append(attr.AmbientCaps, unix.CAP_SYS_ADMIN, unix.CAP_NET_ADMIN)
+	}
+}
+
+// CheckNamespaces reports whether helpers can be started in the given
+// namespaces, by starting a process in them that sets them up and exits. If
+// not, the error says why.
+func CheckNamespaces(ns Namespaces) error {
+	resolved, err := resolveNamespaces(&ns)
+	if err != nil {
+		return err
+	}
+	// The shim exits right after setting up the namespaces if there's no
+	// helper to execute
+	cmd := exec.Command("/proc/self/exe")
+	setNamespaces(cmd, resolved)
+	useShimSpec(cmd, &shimSpec{Namespaces: resolved})
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// setup sets up the namespaces the shim was started in, before it executes
+// the helper at executable.
+func (ns *Namespaces) setup(executable string) error {
+	if ns.Mount {
+		if err := ns.setupMounts(executable); err != nil {
+			return err
+		}
+	}
+	if ns.Network {
+		if err := loopbackUp(); err != nil {
+			return fmt.Errorf("unable to bring up loopback interface: %w", err)
+		}
+	}
+	if ns.User {
+		if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+			return fmt.Errorf("unable to drop capabilities: %w", err)
+		}
+	}
+	return nil
+}
+
+func (ns *Namespaces) setupMounts(executable string) error {
+	// Keep our mounts from propagating back to the parent namespace
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("unable to make mounts private: %w", err)
+	}
+
+	// Hold on to the writable paths, since the tmpfs may hide them
+	var writable []int
+	for _, path := range ns.Writable {
+		fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return fmt.Errorf("unable to open %s: %w", path, err)
+		}
+		defer unix.Close(fd)
+		writable = append(writable, fd)
+	}
+	// The helper has to stay visible for it to be executed
+	hidden := -1
+	if strings.HasPrefix(executable, "/tmp/") {
+		fd, err := unix.Open(executable, unix.O_PATH|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return fmt.Errorf("unable to open %s: %w", executable, err)
+		}
+		defer unix.Close(fd)
+		hidden = fd
+	}
+	// Make everything read-only first, including filesystems mounted beneath
+	// the root, so that only what's mounted afterwards is writable
+	if err := setReadOnly("/", true); err != nil {
+		return fmt.Errorf("unable to make root read-only: %w", err)
+	}
+	if err := unix.Mount("tmpfs", "/tmp", "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777"); err != nil {
+		return fmt.Errorf("unable to mount /tmp: %w", err)
+	}
+	for i, path := range ns.Writable {
+		var stat unix.Stat_t
+		if err := unix.Fstat(writable[i], &stat); err != nil {
+			return err
+		}
+		if err := makeMountPoint(path, stat.Mode&unix.S_IFMT == unix.S_IFDIR); err != nil {
+			return fmt.Errorf("unable to make mount point %s: %w", path, err)
+		}
+		source := fmt.Sprintf("/proc/self/fd/%d", writable[i])
+		if err := unix.Mount(source, path, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+			return fmt.Errorf("unable to keep %s writable: %w", path, err)
+		}
+		// The bind mount is as read-only as the one it came from
+		if err := setReadOnly(path, false); err != nil {
+			return fmt.Errorf("unable to keep %s writable: %w", path, err)
+		}
+	}
+	if hidden >= 0 {
+		if err := makeMountPoint(executable, false); err != nil {
+			return fmt.Errorf("unable to make mount point %s: %w", executable, err)
+		}
+		source := fmt.Sprintf("/proc/self/fd/%d", hidden)
+		if err := unix.Mount(source, executable, "", unix.MS_BIND, ""); err != nil {
+			return fmt.Errorf("unable to keep %s visible: %w", executable, err)
+		}
+		if err := setReadOnly(executable, true); err != nil {
+			return fmt.Errorf("unable to make %s read-only: %w", executable, err)
+		}
+	}
+
+	if ns.PID {
+		// This fails where parts of /proc are hidden from us, as in many
+		// containers. The helper still can't do anything to processes
+		// outside of its namespace then, it only sees them.
+		unix.Mount("proc", "/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "")
+	}
+
+	// The working directory may have been hidden by a mount
+	if wd, err := os.Getwd(); err == nil {
+		os.Chdir(wd)
+	}
+	return nil
+}
+
+// makeMountPoint makes sure that something exists at path to mount a file or
+// directory on.
+func makeMountPoint(path string, dir bool) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if dir {
+		return os.MkdirAll(path, 0755)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// setReadOnly makes the mount at path and all mounts beneath it read-only or
+// writable.
+func setReadOnly(path string, readOnly bool) error {
+	attr := &unix.MountAttr{}
+	if readOnly {
+		attr.Attr_set = unix.MOUNT_ATTR_RDONLY
+	} else {
+		attr.Attr_clr = unix.MOUNT_ATTR_RDONLY
+	}
+	err := unix.MountSetattr(-1, path, unix.AT_RECURSIVE, attr)
+	if !errors.Is(err, unix.ENOSYS) {
+		return err
+	}
+	// mount_setattr exists since Linux 5.12, before that we remount one by
+	// one, starting with the topmost
+	mountPoints, err := mountPointsBeneath(path)
+	if err != nil {
+		return err
+	}
+	for _, mountPoint := range mountPoints {
+		if err := remount(mountPoint, readOnly); err != nil {
+			return fmt.Errorf("unable to remount %s: %w", mountPoint, err)
+		}
+	}
+	return nil
+}
+
+// mountPointsBeneath lists the mount points at or beneath path, ordered so
+// that parents come before their children.
+func mountPointsBeneath(path string) ([]string, error) {
+	data, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var mountPoints []string
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		mountPoint := unescapeMountinfo(fields[4])
+		if seen[mountPoint] {
+			continue
+		}
+		if path == "/" || mountPoint == path || strings.HasPrefix(mountPoint, path+"/") {
+			seen[mountPoint] = true
+			mountPoints = append(mountPoints, mountPoint)
+		}
+	}
+	sort.SliceStable(mountPoints, func(i, j int) bool {
+		return strings.Count(mountPoints[i], "/") < strings.Count(mountPoints[j], "/")
+	})
+	return mountPoints, nil
+}
+
+// unescapeMountinfo undoes the octal escaping of spaces, tabs, newlines and
+// backslashes in the paths in /proc/self/mountinfo.
+func unescapeMountinfo(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// remount makes the mount at path read-only or writable, keeping its other
+// flags, which may be locked in a user namespace.
+func remount(path string, readOnly bool) error {
+	var statfs unix.Statfs_t
+	if err := unix.Statfs(path, &statfs); err != nil {
+		return err
+	}
+	flags := uintptr(unix.MS_REMOUNT | unix.MS_BIND)
+	if readOnly {
+		flags |= unix.MS_RDONLY
+	}
+	for st, ms := range map[int64]uintptr{
+		unix.ST_NOSUID:     unix.MS_NOSUID,
+		unix.ST_NODEV:      unix.MS_NODEV,
+		unix.ST_NOEXEC:     unix.MS_NOEXEC,
+		unix.ST_NOATIME:    unix.MS_NOATIME,
+		unix.ST_NODIRATIME: unix.MS_NODIRATIME,
+		unix.ST_RELATIME:   unix.MS_RELATIME,
+	} {
+		if int64(statfs.Flags)&st != 0 {
+			flags |= ms
+		}
+	}
+	return unix.Mount("", path, "", flags, "")
+}
+
+// loopbackUp brings up the loopback interface of the network namespace.
+func loopbackUp() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	ifr, err := unix.NewIfreq("lo")
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return err
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+	return unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr)
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+// +build !linux
+
+package byteexec
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// Only Linux has namespaces.
+func resolveNamespaces(ns *Namespaces) (*Namespaces, error) {
+	return nil, fmt.Errorf("byteexec: namespaces on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+}
+
+func setNamespaces(cmd *exec.Cmd, ns *Namespaces) {
+}
+
+// CheckNamespaces reports whether helpers can be started in the given
+// namespaces. Namespaces are only supported on Linux.
+func CheckNamespaces(ns Namespaces) error {
+	return fmt.Errorf("byteexec: namespaces on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+}
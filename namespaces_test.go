@@ -0,0 +1,128 @@
+//go:build linux
+// +build linux
+
+package byteexec
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testNamespaces skips the test if helpers can't be started in ns.
+func testNamespaces(t *testing.T, ns Namespaces) {
+	if err := CheckNamespaces(ns); err != nil {
+		t.Skipf("Namespaces unavailable: %v", err)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	for _, user := range []bool{false, true} {
+		name := "privileged"
+		if user {
+			name = "user"
+		}
+		t.Run(name, func(t *testing.T) {
+			t.Run("network", func(t *testing.T) { testNetworkNamespace(t, user) })
+			t.Run("mount", func(t *testing.T) { testMountNamespace(t, user) })
+			t.Run("pid", func(t *testing.T) { testPIDNamespace(t, user) })
+		})
+	}
+}
+
+func testNetworkNamespace(t *testing.T, user bool) {
+	ns := Namespaces{Network: true, User: user}
+	testNamespaces(t, ns)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	be := testHelper(t, Options{Namespaces: &ns})
+	ctx := context.Background()
+	result, err := be.Run(ctx, RunSpec{Args: []string{"dial", l.Addr().String()}})
+	if assert.Error(t, err, "helper shouldn't reach the host's loopback") {
+		assert.Contains(t, string(result.Stderr), "connection refused")
+	}
+	result, err = be.Run(ctx, RunSpec{Args: []string{"interfaces"}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "lo up|loopback|running", strings.TrimSpace(string(result.Stdout)))
+	}
+}
+
+func testMountNamespace(t *testing.T, user bool) {
+	writable := t.TempDir()
+	ns := Namespaces{Mount: true, Writable: []string{writable}, User: user}
+	testNamespaces(t, ns)
+
+	be := testHelper(t, Options{Namespaces: &ns})
+	ctx := context.Background()
+	_, err := be.Run(ctx, RunSpec{Args: []string{"writefile", "/tmp", "10"}})
+	assert.NoError(t, err, "helper should be able to write to /tmp")
+	_, err = be.Run(ctx, RunSpec{Args: []string{"writefile", writable, "10"}})
+	assert.NoError(t, err, "helper should be able to write to writable path")
+	assert.FileExists(t, writable+"/file")
+	result, err := be.Run(ctx, RunSpec{Args: []string{"writefile", "/", "10"}})
+	if assert.Error(t, err) {
+		assert.Contains(t, string(result.Stderr), "read-only file system")
+	}
+	assert.NoFileExists(t, "/tmp/file", "helper's /tmp should be private")
+
+	// Filesystems mounted beneath the root are read-only too
+	if mountPoints, err := mountPointsBeneath("/dev/shm"); err == nil && len(mountPoints) > 0 {
+		result, err := be.Run(ctx, RunSpec{Args: []string{"writefile", "/dev/shm", "10"}})
+		if assert.Error(t, err) {
+			assert.Contains(t, string(result.Stderr), "read-only file system")
+		} else {
+			os.Remove("/dev/shm/file")
+		}
+	}
+}
+
+func testPIDNamespace(t *testing.T, user bool) {
+	ns := Namespaces{PID: true, Mount: true, User: user}
+	testNamespaces(t, ns)
+
+	be := testHelper(t, Options{Namespaces: &ns})
+	result, err := be.Run(context.Background(), RunSpec{Args: []string{"pid"}})
+	if assert.NoError(t, err) {
+		// The shim is pid 1 and executes the helper
+		assert.Equal(t, "1", strings.TrimSpace(string(result.Stdout)))
+	}
+}
+
+func TestPIDNamespaceStop(t *testing.T) {
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skipf("No sleep: %v", err)
+	}
+	ns := Namespaces{PID: true}
+	if CheckNamespaces(ns) != nil {
+		ns.User = true
+	}
+	testNamespaces(t, ns)
+
+	// Unlike Go programs, sleep has no handler for SIGTERM, which it ignores
+	// as pid 1
+	be := &Exec{Filename: sleep, opts: Options{Namespaces: &ns, StopTimeout: 30 * time.Second}}
+	p, err := be.Start(context.Background(), be.Command("60"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	start := time.Now()
+	p.Stop()
+	assert.Less(t, time.Since(start), 10*time.Second, "helper should have been killed right away")
+}
+
+func TestUnescapeMountinfo(t *testing.T) {
+	assert.Equal(t, "/mnt/a b\\c", unescapeMountinfo(`/mnt/a\040b\134c`))
+	assert.Equal(t, "/plain", unescapeMountinfo("/plain"))
+	assert.Equal(t, `/odd\`, unescapeMountinfo(`/odd\`))
+}
@@ -0,0 +1,156 @@
+package byteexec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// OrphanPolicy determines how an Exec tracks the processes it starts so that
+// helpers left over from a crashed previous run can be found later.
+type OrphanPolicy int
+
+const (
+	// OrphansIgnore doesn't track processes.
+	OrphansIgnore OrphanPolicy = iota
+
+	// OrphansReport writes a pidfile next to the executable for every Process
+	// started with Start, and reports running helpers whose parent has died
+	// when the Exec is created.
+	OrphansReport
+
+	// OrphansKill is like OrphansReport, but also kills the process groups of
+	// the helpers that are left over.
+	OrphansKill
+)
+
+// Orphan is a helper left over from a previous run, as identified by its
+// pidfile.
+type Orphan struct {
+	// Pid is the process id of the helper.
+	Pid int
+
+	// Pidfile is the path of the helper's pidfile.
+	Pidfile string
+
+	// Killed indicates whether the helper was killed because of OrphansKill.
+	Killed bool
+}
+
+// Orphans returns the helpers from previous runs that were found running when
+// this Exec was created. It is always empty unless Options.Orphans enables
+// tracking.
+func (be *Exec) Orphans() []Orphan {
+	return be.orphans
+}
+
+// pidfileSuffix is appended to the filename of the executable and the pid of
+// each helper to name its pidfile.
+const pidfileSuffix = ".pid"
+
+// pidfile identifies a helper and its parent by pid and start time, so that a
+// pid that has since been recycled for another process isn't mistaken for the
+// helper or its parent.
+type pidfile struct {
+	pid             int
+	startTime       uint64
+	parentPid       int
+	parentStartTime uint64
+}
+
+func pidfileName(filename string, pid int) string {
+	return fmt.Sprintf("%s.%d%s", filename, pid, pidfileSuffix)
+}
+
+// writePidfile records the helper with the given pid, which is a child of this
+// process.
+func writePidfile(filename string, pid int) (string, error) {
+	startTime, _, err := processInfo(pid)
+	if err != nil {
+		return "", err
+	}
+	parentStartTime, _, err := processInfo(os.Getpid())
+	if err != nil {
+		return "", err
+	}
+	name := pidfileName(filename, pid)
+	contents := fmt.Sprintf("%d %d %d %d\n", pid, startTime, os.Getpid(), parentStartTime)
+	return name, os.WriteFile(name, []byte(contents), 0644)
+}
+
+func readPidfile(name string) (*pidfile, error) {
+	contents, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	pf := &pidfile{}
+	_, err = fmt.Sscan(string(contents), &pf.pid, &pf.startTime, &pf.parentPid, &pf.parentStartTime)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pidfile %s: %w", name, err)
+	}
+	return pf, nil
+}
+
+// isRunning checks whether the process with the given pid is the one that was
+// started at startTime.
+func isRunning(pid int, startTime uint64) bool {
+	actualStartTime, _, err := processInfo(pid)
+	return err == nil && actualStartTime == startTime
+}
+
+// findOrphans looks for pidfiles of helpers running filename and handles the
+// ones that outlived their parent according to policy. Pidfiles of helpers that
+// are no longer running are removed.
+func findOrphans(filename string, policy OrphanPolicy, log Logger) []Orphan {
+	if policy == OrphansIgnore {
+		return nil
+	}
+	entries, err := os.ReadDir(filepath.Dir(filename))
+	if err != nil {
+		log.Error("unable to look for pidfiles", "path", filename, "error", err)
+		return nil
+	}
+
+	var orphans []Orphan
+	prefix := filepath.Base(filename) + "."
+	for _, entry := range entries {
+		middle, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok {
+			continue
+		}
+		middle, ok = strings.CutSuffix(middle, pidfileSuffix)
+		if _, err := strconv.Atoi(middle); !ok || err != nil {
+			continue
+		}
+		name := filepath.Join(filepath.Dir(filename), entry.Name())
+		pf, err := readPidfile(name)
+		if err != nil {
+			log.Debug("ignoring pidfile", "pidfile", name, "error", err)
+			continue
+		}
+		if !isRunning(pf.pid, pf.startTime) {
+			log.Debug("removing stale pidfile", "pidfile", name, "pid", pf.pid)
+			os.Remove(name)
+			continue
+		}
+		if isRunning(pf.parentPid, pf.parentStartTime) {
+			// Still owned by a live process, possibly this one
+			continue
+		}
+
+		orphan := Orphan{Pid: pf.pid, Pidfile: name}
+		if policy == OrphansKill {
+			if err := killOrphan(pf.pid); err != nil {
+				log.Error("unable to kill orphaned helper", "path", filename, "pid", pf.pid, "error", err)
+			} else {
+				orphan.Killed = true
+				os.Remove(name)
+			}
+		}
+		log.Info("found orphaned helper", "path", filename, "pid", pf.pid, "killed", orphan.Killed)
+		orphans = append(orphans, orphan)
+	}
+	return orphans
+}
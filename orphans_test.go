@@ -0,0 +1,49 @@
+//go:build linux
+// +build linux
+
+package byteexec
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrphans(t *testing.T) {
+	helperPid := startAndKillIntermediate(t, testHelper(t, Options{}), "orphans")
+	pidfile := pidfileName(helperExec.Filename, helperPid)
+	assert.FileExists(t, pidfile, "Helper should have a pidfile")
+	assert.False(t, processGone(helperPid), "Helper should have survived the intermediate")
+
+	be, err := ExistingWithOptions(helperExec.Filename, Options{Orphans: OrphansReport})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []Orphan{{Pid: helperPid, Pidfile: pidfile}}, be.Orphans())
+	assert.False(t, processGone(helperPid), "Reporting orphans should leave them running")
+
+	be, err = ExistingWithOptions(helperExec.Filename, Options{Orphans: OrphansKill})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []Orphan{{Pid: helperPid, Pidfile: pidfile, Killed: true}}, be.Orphans())
+	assert.True(t, eventually(func() bool { return processGone(helperPid) }, 2*time.Second), "Orphan should have been killed")
+	_, err = os.Stat(pidfile)
+	assert.True(t, os.IsNotExist(err), "Pidfile should have been removed")
+
+	// Processes owned by a live parent aren't orphans
+	p, err := be.Start(context.Background(), be.Command("sleep"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+	assert.FileExists(t, pidfileName(be.Filename, p.Pid()))
+	be, err = ExistingWithOptions(helperExec.Filename, Options{Orphans: OrphansKill})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, be.Orphans())
+}
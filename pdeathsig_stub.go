@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package byteexec
+
+import (
+	"os/exec"
+)
+
+// Only Linux supports parent death signals.
+func setParentDeathSignal(cmd *exec.Cmd) {
+}
@@ -0,0 +1,410 @@
+package byteexec
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultIdleTimeout is the default time after which idle workers in excess of
+// PoolOptions.Min are stopped.
+const DefaultIdleTimeout = time.Minute
+
+var (
+	// ErrPoolBusy is returned by Pool.Acquire when all workers are busy and
+	// the queue of waiting jobs is full.
+	ErrPoolBusy = errors.New("pool busy")
+
+	// ErrPoolClosed is returned by Pool.Acquire once the pool is closed.
+	ErrPoolClosed = errors.New("pool closed")
+)
+
+// PoolOptions configures a Pool.
+type PoolOptions struct {
+	// Args are the arguments passed to every worker.
+	Args []string
+
+	// Min is the number of workers kept running even when there's nothing to
+	// do.
+	Min int
+
+	// Max is the most workers that run at the same time. If zero, it's the
+	// same as Min, or 1 if Min is zero too.
+	Max int
+
+	// MaxJobs, if positive, is the number of jobs after which a worker is
+	// replaced with a fresh one.
+	MaxJobs int
+
+	// MaxQueue, if positive, is the most jobs that wait for a worker when all
+	// of them are busy. Once it's reached, Acquire fails with ErrPoolBusy
+	// instead of waiting. If zero, jobs always wait.
+	MaxQueue int
+
+	// IdleTimeout is the time after which idle workers in excess of Min are
+	// stopped. If zero, DefaultIdleTimeout is used.
+	IdleTimeout time.Duration
+}
+
+// Worker is a running helper handed out by a Pool for a job. The job talks to
+// the helper over its stdin and stdout.
+type Worker struct {
+	// Process is the running helper.
+	Process *Process
+
+	// Stdin is connected to the helper's stdin.
+	Stdin io.Writer
+
+	// Stdout is connected to the helper's stdout.
+	Stdout *bufio.Reader
+
+	stdin     io.Closer
+	stdout    io.Closer
+	jobs      int
+	failed    bool
+	idleSince time.Time
+}
+
+// Fail marks the worker as broken, so that it's replaced instead of being
+// reused once released. Use it when a job leaves the helper in an unknown
+// state, for example after a protocol error.
+func (w *Worker) Fail() {
+	w.failed = true
+}
+
+func (w *Worker) exited() bool {
+	select {
+	case <-w.Process.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *Worker) stop() {
+	w.stdin.Close()
+	w.Process.Stop()
+	w.stdout.Close()
+}
+
+// PoolStats is a snapshot of the state of a Pool.
+type PoolStats struct {
+	// Workers is the number of workers running or starting.
+	Workers int
+	// Idle and Busy are the number of workers waiting for and doing jobs.
+	Idle int
+	Busy int
+	// Waiting is the number of jobs waiting for a worker.
+	Waiting int
+	// Started is the total number of workers started.
+	Started int64
+	// Recycled is the total number of workers stopped after being used.
+	Recycled int64
+	// Jobs is the total number of jobs completed.
+	Jobs int64
+	// Failures is the total number of jobs that failed, including workers
+	// that failed to start.
+	Failures int64
+}
+
+// acquired is what a waiting job receives, either a worker or the reason it
+// won't get one.
+type acquired struct {
+	w   *Worker
+	err error
+}
+
+// Pool keeps a number of instances of a helper running and hands them out to
+// jobs one at a time. Jobs wait for a worker when all of them are busy, and
+// the pool starts more workers as needed, up to PoolOptions.Max. Pool is safe
+// for concurrent use.
+type Pool struct {
+	be   *Exec
+	opts PoolOptions
+
+	mu       sync.Mutex
+	idle     []*Worker
+	waiters  []chan acquired
+	workers  int
+	starting int
+	busy     int
+	closed   bool
+	stats    PoolStats
+	quit     chan struct{}
+	reaped   chan struct{}
+}
+
+// NewPool creates a Pool of workers running the helper and starts the first
+// PoolOptions.Min of them.
+func (be *Exec) NewPool(opts PoolOptions) (*Pool, error) {
+	if opts.Min < 0 || opts.Max < 0 {
+		return nil, fmt.Errorf("invalid pool size %d to %d", opts.Min, opts.Max)
+	}
+	if opts.Max == 0 {
+		opts.Max = opts.Min
+		if opts.Max == 0 {
+			opts.Max = 1
+		}
+	}
+	if opts.Max < opts.Min {
+		return nil, fmt.Errorf("invalid pool size %d to %d", opts.Min, opts.Max)
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = DefaultIdleTimeout
+	}
+	p := &Pool{be: be, opts: opts, quit: make(chan struct{}), reaped: make(chan struct{})}
+	p.mu.Lock()
+	p.grow()
+	p.mu.Unlock()
+	go p.reap()
+	return p, nil
+}
+
+// Do runs fn with a worker from the pool. If fn returns an error, the worker is
+// replaced instead of being reused.
+func (p *Pool) Do(ctx context.Context, fn func(w *Worker) error) error {
+	w, err := p.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	err = fn(w)
+	if err != nil {
+		w.Fail()
+	}
+	p.Release(w)
+	return err
+}
+
+// Acquire takes an idle worker from the pool, starting a new one or waiting for
+// one to be released if there's none. Every worker acquired must be released
+// with Release.
+func (p *Pool) Acquire(ctx context.Context) (*Worker, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
+	for len(p.idle) > 0 {
+		// Take the most recently used worker, so that the others can idle out
+		w := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if w.exited() {
+			p.retire(w)
+			continue
+		}
+		p.busy++
+		p.mu.Unlock()
+		return w, nil
+	}
+	if p.opts.MaxQueue > 0 && len(p.waiters) >= p.opts.MaxQueue {
+		p.mu.Unlock()
+		return nil, ErrPoolBusy
+	}
+	waiter := make(chan acquired, 1)
+	p.waiters = append(p.waiters, waiter)
+	p.grow()
+	p.mu.Unlock()
+
+	select {
+	case a := <-waiter:
+		return a.w, a.err
+	case <-ctx.Done():
+		p.mu.Lock()
+		for i, other := range p.waiters {
+			if other == waiter {
+				p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+				break
+			}
+		}
+		p.mu.Unlock()
+		// We may have been handed a worker in the meantime
+		select {
+		case a := <-waiter:
+			if a.w != nil {
+				p.mu.Lock()
+				p.busy--
+				p.put(a.w)
+				p.mu.Unlock()
+			}
+		default:
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// Release returns a worker acquired with Acquire to the pool. Workers that
+// failed, exited or reached PoolOptions.MaxJobs are replaced.
+func (p *Pool) Release(w *Worker) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.busy--
+	w.jobs++
+	p.stats.Jobs++
+	if w.failed {
+		p.stats.Failures++
+	}
+	if p.closed || w.failed || w.exited() || (p.opts.MaxJobs > 0 && w.jobs >= p.opts.MaxJobs) {
+		p.stats.Recycled++
+		p.retire(w)
+		p.grow()
+		return
+	}
+	p.put(w)
+}
+
+// Stats returns the current state of the pool.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := p.stats
+	stats.Workers = p.workers
+	stats.Idle = len(p.idle)
+	stats.Busy = p.busy
+	stats.Waiting = len(p.waiters)
+	return stats
+}
+
+// Close stops the idle workers and makes waiting and future calls to Acquire
+// fail with ErrPoolClosed. Busy workers are stopped once they're released.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	for _, waiter := range p.waiters {
+		waiter <- acquired{err: ErrPoolClosed}
+	}
+	p.waiters = nil
+	idle := p.idle
+	p.idle = nil
+	p.workers -= len(idle)
+	p.mu.Unlock()
+	close(p.quit)
+
+	for _, w := range idle {
+		w.stop()
+	}
+	<-p.reaped
+	return nil
+}
+
+// put hands w to the first waiting job or makes it idle. p.mu must be held.
+func (p *Pool) put(w *Worker) {
+	if len(p.waiters) > 0 {
+		waiter := p.waiters[0]
+		p.waiters = p.waiters[1:]
+		p.busy++
+		waiter <- acquired{w: w}
+		return
+	}
+	w.idleSince = time.Now()
+	p.idle = append(p.idle, w)
+}
+
+// retire stops w in the background. p.mu must be held.
+func (p *Pool) retire(w *Worker) {
+	p.workers--
+	go w.stop()
+}
+
+// grow starts workers until there are at least Min of them and one for every
+// waiting job, up to Max. p.mu must be held.
+func (p *Pool) grow() {
+	if p.closed {
+		return
+	}
+	for p.workers < p.opts.Max && (p.workers < p.opts.Min || len(p.waiters) > p.starting) {
+		p.workers++
+		p.starting++
+		p.stats.Started++
+		go p.startWorker()
+	}
+}
+
+func (p *Pool) startWorker() {
+	w, err := p.newWorker()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.starting--
+	if err != nil {
+		p.workers--
+		p.stats.Failures++
+		p.be.logger().Error("unable to start pool worker", "path", p.be.Filename, "error", err)
+		// Fail a waiting job rather than retrying forever
+		if len(p.waiters) > 0 {
+			waiter := p.waiters[0]
+			p.waiters = p.waiters[1:]
+			waiter <- acquired{err: err}
+		}
+		return
+	}
+	if p.closed {
+		p.workers--
+		go w.stop()
+		return
+	}
+	p.put(w)
+}
+
+func (p *Pool) newWorker() (*Worker, error) {
+	cmd := p.be.Command(p.opts.Args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	// Use our own pipe for stdout, since the Process waits on cmd right away,
+	// which would close a pipe from StdoutPipe while we still read from it.
+	stdout, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = pw
+	proc, err := p.be.Start(context.Background(), cmd)
+	pw.Close()
+	if err != nil {
+		stdout.Close()
+		return nil, err
+	}
+	return &Worker{
+		Process: proc,
+		Stdin:   stdin,
+		Stdout:  bufio.NewReader(stdout),
+		stdin:   stdin,
+		stdout:  stdout,
+	}, nil
+}
+
+// reap periodically stops workers that have been idle for too long, as long as
+// that leaves at least Min of them.
+func (p *Pool) reap() {
+	defer close(p.reaped)
+	ticker := time.NewTicker(p.opts.IdleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.quit:
+			return
+		}
+
+		p.mu.Lock()
+		kept := p.idle[:0]
+		for _, w := range p.idle {
+			if w.exited() || (p.workers > p.opts.Min && time.Since(w.idleSince) >= p.opts.IdleTimeout) {
+				p.retire(w)
+				continue
+			}
+			kept = append(kept, w)
+		}
+		p.idle = kept
+		p.grow()
+		p.mu.Unlock()
+	}
+}
@@ -0,0 +1,114 @@
+//go:build !windows
+// +build !windows
+
+package byteexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// echo sends line to a worker running the cat helper and reads it back.
+func echo(w *Worker, line string) error {
+	if _, err := fmt.Fprintln(w.Stdin, line); err != nil {
+		return err
+	}
+	reply, err := w.Stdout.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if reply != line+"\n" {
+		return fmt.Errorf("unexpected reply %q", reply)
+	}
+	return nil
+}
+
+func TestPool(t *testing.T) {
+	be := testHelper(t, Options{})
+	pool, err := be.NewPool(PoolOptions{Args: []string{"cat"}, Min: 1, Max: 2, MaxJobs: 2, MaxQueue: 1})
+	if err != nil {
+		t.Fatalf("Unable to create pool: %v", err)
+	}
+	defer pool.Close()
+	ctx := context.Background()
+
+	// Workers are reused until they reach MaxJobs
+	var pids []int
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, pool.Do(ctx, func(w *Worker) error {
+			pids = append(pids, w.Process.Pid())
+			return echo(w, "hello")
+		}))
+	}
+	assert.Equal(t, pids[0], pids[1])
+	assert.NotEqual(t, pids[1], pids[2])
+
+	// Failed workers are replaced right away
+	assert.Error(t, pool.Do(ctx, func(w *Worker) error {
+		return errors.New("broken")
+	}))
+	stats := pool.Stats()
+	assert.EqualValues(t, 4, stats.Jobs)
+	assert.EqualValues(t, 1, stats.Failures)
+	assert.EqualValues(t, 2, stats.Recycled)
+
+	// With both workers busy, one job can wait and the next is turned away
+	w1, err := pool.Acquire(ctx)
+	assert.NoError(t, err)
+	w2, err := pool.Acquire(ctx)
+	assert.NoError(t, err)
+	waited := make(chan error)
+	go func() {
+		waited <- pool.Do(ctx, func(w *Worker) error {
+			return echo(w, "waited")
+		})
+	}()
+	assert.True(t, eventually(func() bool { return pool.Stats().Waiting == 1 }, 2*time.Second), "job should be waiting")
+	_, err = pool.Acquire(ctx)
+	assert.ErrorIs(t, err, ErrPoolBusy)
+	pool.Release(w1)
+	assert.NoError(t, <-waited)
+	pool.Release(w2)
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	w1, _ = pool.Acquire(ctx)
+	w2, _ = pool.Acquire(ctx)
+	_, err = pool.Acquire(timeoutCtx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	pool.Release(w1)
+	pool.Release(w2)
+
+	pool.Close()
+	_, err = pool.Acquire(ctx)
+	assert.ErrorIs(t, err, ErrPoolClosed)
+	assert.True(t, eventually(func() bool { return pool.Stats().Workers == 0 }, 2*time.Second), "workers should be stopped")
+}
+
+func TestPoolScalesDown(t *testing.T) {
+	be := testHelper(t, Options{})
+	pool, err := be.NewPool(PoolOptions{Args: []string{"cat"}, Min: 1, Max: 3, IdleTimeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Unable to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	var workers []*Worker
+	for i := 0; i < 3; i++ {
+		w, err := pool.Acquire(context.Background())
+		if !assert.NoError(t, err) {
+			return
+		}
+		workers = append(workers, w)
+	}
+	assert.Equal(t, 3, pool.Stats().Workers)
+	for _, w := range workers {
+		pool.Release(w)
+	}
+	assert.True(t, eventually(func() bool { return pool.Stats().Workers == 1 }, 2*time.Second), "idle workers should be stopped")
+}
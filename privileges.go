@@ -0,0 +1,37 @@
+package byteexec
+
+// Privileges makes helpers run as an unprivileged user instead of inheriting
+// the privileges of the calling process, which is useful when it runs as
+// root. Helpers run with the user's uid and gid, without supplementary groups
+// and with no_new_privs set, so that they can't regain privileges by executing
+// setuid programs. Dropping privileges requires root and is only supported on
+// Linux.
+//
+// The helper is opened before dropping privileges, so the user doesn't need
+// access to its directory. Unless the user can already execute the helper,
+// creating the Exec changes the helper's group to the user's group, which is
+// then allowed to read and execute it, so every member of that group can. An
+// executable can only be handed to one group at a time: creating an Exec for
+// a file that another Exec in this process runs as a different group fails.
+type Privileges struct {
+	// User is the name or numeric id of the user helpers run as. If empty,
+	// they run as nobody, or uid 65534 if there's no such user.
+	User string
+
+	// Group is the name or numeric id of the group helpers run as. If empty,
+	// the primary group of User is used.
+	Group string
+
+	// AmbientCaps are capabilities helpers keep, for example
+	// unix.CAP_NET_ADMIN for a helper that configures a TUN device. They're
+	// kept as ambient capabilities, so they're passed on to programs the
+	// helper executes in turn.
+	AmbientCaps []uintptr
+}
+
+// privilegesSpec is the user the shim switches to.
+type privilegesSpec struct {
+	UID         int
+	GID         int
+	AmbientCaps []uintptr `json:",omitempty"`
+}
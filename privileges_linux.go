@@ -0,0 +1,161 @@
+package byteexec
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// nobody is the uid and gid of nobody on most systems.
+const nobody = 65534
+
+// resolvePrivileges looks up the user and group helpers run as.
+func resolvePrivileges(p *Privileges) (*privilegesSpec, error) {
+	spec := &privilegesSpec{UID: nobody, GID: nobody, AmbientCaps: p.AmbientCaps}
+	name := p.User
+	if name == "" {
+		name = "nobody"
+	}
+	u, err := lookupUser(name)
+	switch {
+	case err == nil:
+		if spec.UID, err = strconv.Atoi(u.Uid); err != nil {
+			return nil, fmt.Errorf("byteexec: invalid uid of user %s: %w", name, err)
+		}
+		if spec.GID, err = strconv.Atoi(u.Gid); err != nil {
+			return nil, fmt.Errorf("byteexec: invalid gid of user %s: %w", name, err)
+		}
+	case p.User != "":
+		return nil, fmt.Errorf("byteexec: %w", err)
+	}
+	if p.Group != "" {
+		g, err := lookupGroup(p.Group)
+		if err != nil {
+			return nil, fmt.Errorf("byteexec: %w", err)
+		}
+		if spec.GID, err = strconv.Atoi(g.Gid); err != nil {
+			return nil, fmt.Errorf("byteexec: invalid gid of group %s: %w", p.Group, err)
+		}
+	}
+	return spec, nil
+}
+
+// lookupUser looks up a user by name or, failing that, by numeric id.
+func lookupUser(name string) (*user.User, error) {
+	u, err := user.Lookup(name)
+	var unknown user.UnknownUserError
+	if errors.As(err, &unknown) {
+		if _, atoiErr := strconv.Atoi(name); atoiErr == nil {
+			return user.LookupId(name)
+		}
+	}
+	return u, err
+}
+
+// lookupGroup looks up a group by name or, failing that, by numeric id.
+func lookupGroup(name string) (*user.Group, error) {
+	g, err := user.LookupGroup(name)
+	var unknown user.UnknownGroupError
+	if errors.As(err, &unknown) {
+		if _, atoiErr := strconv.Atoi(name); atoiErr == nil {
+			return user.LookupGroupId(name)
+		}
+	}
+	return g, err
+}
+
+// executeGroups records the group that this process handed each helper run
+// with Privileges to, so that Execs sharing a file can't take it away from
+// each other.
+var (
+	executeGroupsMu sync.Mutex
+	executeGroups   = make(map[string]int)
+)
+
+// allowExecute makes sure that the user can execute the file at path, which
+// NewFileMode only allows its owner to, by handing it to the user's group if
+// necessary. The group may read and execute the file, but not write it. The
+// directories leading to it don't matter, since the shim opens the file before
+// dropping privileges. It fails if another Exec already handed the file to a
+// different group.
+func (p *privilegesSpec) allowExecute(path string) error {
+	executeGroupsMu.Lock()
+	defer executeGroupsMu.Unlock()
+	if gid, found := executeGroups[path]; found && gid != p.GID {
+		return fmt.Errorf("another Exec runs it as group %d, use a separate copy for group %d", gid, p.GID)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	mode := info.Mode().Perm()
+	if !ok || int(stat.Uid) == p.UID || mode&0001 != 0 {
+		// The group doesn't matter
+		return nil
+	}
+	if int(stat.Gid) != p.GID {
+		if err := os.Chown(path, -1, p.GID); err != nil {
+			return err
+		}
+	}
+	if mode&0070 != 0050 {
+		if err := os.Chmod(path, mode&^0070|0050); err != nil {
+			return err
+		}
+	}
+	executeGroups[path] = p.GID
+	return nil
+}
+
+// apply switches the calling thread to the user, keeping only the ambient
+// capabilities, and sets no_new_privs.
+func (p *privilegesSpec) apply() error {
+	if len(p.AmbientCaps) > 0 {
+		// Keep our permitted capabilities across setuid, so that we can
+		// raise the ambient ones afterwards
+		if err := unix.Prctl(unix.PR_SET_KEEPCAPS, 1, 0, 0, 0); err != nil {
+			return fmt.Errorf("unable to keep capabilities: %w", err)
+		}
+	}
+	if err := syscall.Setgroups(nil); err != nil {
+		return fmt.Errorf("unable to clear supplementary groups: %w", err)
+	}
+	if err := syscall.Setgid(p.GID); err != nil {
+		return fmt.Errorf("unable to set gid %d: %w", p.GID, err)
+	}
+	if err := syscall.Setuid(p.UID); err != nil {
+		return fmt.Errorf("unable to set uid %d: %w", p.UID, err)
+	}
+	if len(p.AmbientCaps) > 0 {
+		// Ambient capabilities have to be permitted and inheritable
+		header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+		var data [2]unix.CapUserData
+		for _, c := range p.AmbientCaps {
+			if c >= 64 {
+				return fmt.Errorf("invalid capability %d", c)
+			}
+			data[c/32].Permitted |= 1 << (c % 32)
+			data[c/32].Inheritable |= 1 << (c % 32)
+			data[c/32].Effective |= 1 << (c % 32)
+		}
+		if err := unix.Capset(&header, &data[0]); err != nil {
+			return fmt.Errorf("unable to set capabilities: %w", err)
+		}
+		for _, c := range p.AmbientCaps {
+			if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_RAISE, c, 0, 0); err != nil {
+				return fmt.Errorf("unable to keep capability %d: %w", c, err)
+			}
+		}
+	}
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("unable to set no_new_privs: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package byteexec
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+// Only Linux supports dropping privileges.
+func resolvePrivileges(p *Privileges) (*privilegesSpec, error) {
+	return nil, fmt.Errorf("byteexec: dropping privileges on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+}
+
+func (p *privilegesSpec) allowExecute(path string) error {
+	return errors.ErrUnsupported
+}
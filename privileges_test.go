@@ -0,0 +1,67 @@
+//go:build linux
+// +build linux
+
+package byteexec
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestPrivileges(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Dropping privileges requires root")
+	}
+	be := testHelper(t, Options{Privileges: &Privileges{}})
+	ctx := context.Background()
+
+	result, err := be.Run(ctx, RunSpec{Args: []string{"status"}})
+	if assert.NoError(t, err) {
+		status := string(result.Stdout)
+		assert.Contains(t, status, "Uid: 65534 65534 65534 65534\n")
+		assert.Contains(t, status, "Gid: 65534 65534 65534 65534\n")
+		assert.Contains(t, status, "Groups:\n", "supplementary groups should be cleared")
+		assert.Contains(t, status, "CapAmb: 0000000000000000\n")
+		assert.Contains(t, status, "NoNewPrivs: 1\n")
+	}
+	// Only nobody's group may execute the helper, and its directory stays
+	// private
+	if info, err := os.Stat(be.Filename); assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0), info.Mode()&0001, "others shouldn't be able to execute the helper")
+	}
+	if info, err := os.Stat(filepath.Dir(be.Filename)); assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0), info.Mode()&0077, "helper's directory should stay private")
+	}
+	result, err = be.Run(ctx, RunSpec{Args: []string{"writefile", "/root", "10"}})
+	if assert.Error(t, err) {
+		assert.Contains(t, string(result.Stderr), "permission denied")
+	}
+
+	// Another group needs a copy of its own
+	opts := Options{Privileges: &Privileges{User: "65534", Group: "0", AmbientCaps: []uintptr{unix.CAP_NET_ADMIN}}}
+	_, err = newExec(be.Filename, opts)
+	assert.ErrorContains(t, err, "another Exec runs it as group 65534")
+	data, err := os.ReadFile(be.Filename)
+	if !assert.NoError(t, err) {
+		return
+	}
+	be, err = NewWithOptions(data, filepath.Join(t.TempDir(), helperName), opts)
+	if !assert.NoError(t, err) {
+		return
+	}
+	result, err = be.Run(ctx, RunSpec{Args: []string{"status"}})
+	if assert.NoError(t, err) {
+		status := string(result.Stdout)
+		assert.Contains(t, status, "Uid: 65534 65534 65534 65534\n")
+		assert.Contains(t, status, "Gid: 0 0 0 0\n")
+		assert.Contains(t, status, "CapAmb: 0000000000001000\n", "CAP_NET_ADMIN should be kept")
+	}
+
+	_, err = newExec(be.Filename, Options{Privileges: &Privileges{User: "nonexistent-user"}})
+	assert.ErrorContains(t, err, "nonexistent-user")
+}
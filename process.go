@@ -0,0 +1,284 @@
+package byteexec
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// DefaultStopTimeout is the grace period given to a stopped Process before it
+// is killed, unless Options.StopTimeout says otherwise.
+const DefaultStopTimeout = 5 * time.Second
+
+// Process is a child process started with Exec.Start. Unlike a plain exec.Cmd,
+// a Process runs in its own process group and is stopped gracefully: the whole
+// group first receives the Exec's stop signal and only gets killed once the
+// grace period has elapsed. Process is safe for concurrent use.
+type Process struct {
+	// Cmd is the underlying command. It must not be waited on directly. Cmd
+	// is nil for a Process attached to an existing instance of a helper.
+	Cmd *exec.Cmd
+
+	proc     *os.Process
+	be       *Exec
+	done     chan struct{}
+	err      error
+	usage    cgroupUsage
+	stopOnce sync.Once
+	// unverified is set for an attached Process that isn't known to lead
+	// its own process group, so that only the process itself is signalled.
+	unverified bool
+}
+
+// Start starts cmd, which should have been created with this Exec's Command
+// method, and returns a Process for managing it. When ctx is done, the Process
+// is stopped as if by calling Stop.
+//
+// If Options.SingleInstance is set, Start first makes sure that no other
+// instance of the helper is running, see SingleInstance.
+//
+// If cmd starts the helper through the shim that applies settings such as
+// Options.Limits, Start waits until the shim has executed the helper and fails
+// if it was unable to.
+func (be *Exec) Start(ctx context.Context, cmd *exec.Cmd) (*Process, error) {
+	var lock *instanceLock
+	if be.opts.SingleInstance != nil {
+		var attached *Process
+		var err error
+		lock, attached, err = be.lockInstance(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if attached != nil {
+			return attached, nil
+		}
+		// The child inherits the lock, so that it's held for as long as the
+		// child is running, even if we die first.
+		cmd.ExtraFiles = append(cmd.ExtraFiles, lock.file)
+	}
+
+	var loggers []*lineLogger
+	closeLoggers := func() {
+		for _, l := range loggers {
+			l.close()
+		}
+	}
+	if be.opts.LogOutput {
+		var err error
+		loggers, err = be.logOutput(cmd)
+		if err != nil {
+			if lock != nil {
+				lock.close()
+			}
+			return nil, err
+		}
+	}
+
+	shim, err := watchShim(cmd)
+	if err != nil {
+		closeLoggers()
+		if lock != nil {
+			lock.close()
+		}
+		return nil, err
+	}
+	cg, err := be.prepareCgroup(cmd)
+	if err != nil {
+		if shim != nil {
+			shim.wait(false)
+		}
+		closeLoggers()
+		if lock != nil {
+			lock.close()
+		}
+		return nil, err
+	}
+
+	setProcessGroup(cmd)
+	err = cmd.Start()
+	if cg != nil {
+		pid := 0
+		if err == nil {
+			pid = cmd.Process.Pid
+		}
+		if cgErr := cg.started(pid); cgErr != nil {
+			if be.opts.Cgroup.Required {
+				killGroup(cmd.Process)
+				cmd.Wait()
+				err = cgErr
+			} else {
+				be.logger().Error("unable to confine process to cgroup", "path", be.Filename, "pid", pid, "error", cgErr)
+			}
+		}
+	}
+	if shim != nil {
+		if setupErr := shim.wait(err == nil); setupErr != nil {
+			cmd.Wait()
+			err = setupErr
+		}
+	}
+	for _, l := range loggers {
+		if err == nil {
+			l.start(cmd.Process.Pid)
+		} else {
+			l.start(0)
+			l.close()
+		}
+	}
+	if lock != nil {
+		if err == nil {
+			lock.setOwner(cmd.Process.Pid)
+		}
+		lock.close()
+	}
+	if err != nil {
+		releaseChild(cmd)
+		be.logger().Error("unable to start process", "path", be.Filename, "error", err)
+		if cg != nil {
+			cg.remove()
+		}
+		return nil, err
+	}
+	p := &Process{
+		Cmd:  cmd,
+		proc: cmd.Process,
+		be:   be,
+		done: make(chan struct{}),
+	}
+	be.logger().Debug("started process", "path", be.Filename, "pid", p.Pid())
+
+	var pidfile string
+	if be.opts.Orphans != OrphansIgnore {
+		var err error
+		pidfile, err = writePidfile(be.Filename, p.Pid())
+		if err != nil {
+			be.logger().Error("unable to write pidfile", "path", be.Filename, "pid", p.Pid(), "error", err)
+		}
+	}
+
+	go func() {
+		p.err = cmd.Wait()
+		releaseChild(cmd)
+		closeLoggers()
+		if pidfile != "" {
+			os.Remove(pidfile)
+		}
+		if cg != nil {
+			p.usage = cg.usage()
+			if err := cg.remove(); err != nil {
+				be.logger().Error("unable to remove cgroup", "path", be.Filename, "pid", p.Pid(), "error", err)
+			}
+		}
+		close(p.done)
+		be.logger().Debug("process exited", "path", be.Filename, "pid", p.Pid(), "state", cmd.ProcessState)
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.Stop()
+		case <-p.done:
+		}
+	}()
+	return p, nil
+}
+
+// Pid returns the process id of the process, which is also the id of its
+// process group.
+func (p *Process) Pid() int {
+	return p.proc.Pid
+}
+
+// Done returns a channel that's closed once the process has exited.
+func (p *Process) Done() <-chan struct{} {
+	return p.done
+}
+
+// Wait waits for the process to exit and returns the same error as
+// exec.Cmd.Wait.
+func (p *Process) Wait() error {
+	<-p.done
+	return p.err
+}
+
+// Stop sends the stop signal to the process group, waits up to the stop
+// timeout for the group to exit and then kills anything that's left. It
+// returns the result of Wait. It is safe to call Stop more than once and after
+// the process has already exited.
+func (p *Process) Stop() error {
+	p.stopOnce.Do(p.stop)
+	return p.Wait()
+}
+
+func (p *Process) stop() {
+	log := p.be.logger()
+	select {
+	case <-p.done:
+		if !p.groupAlive() {
+			return
+		}
+	default:
+	}
+
+	sig := p.be.opts.StopSignal
+	if sig == nil {
+		sig = defaultStopSignal
+	}
+	timeout := p.be.opts.StopTimeout
+	if timeout <= 0 {
+		timeout = DefaultStopTimeout
+	}
+
+	if ns := p.be.opts.Namespaces; ns != nil && ns.PID && !catchesSignal(p.Pid(), sig) {
+		// As the init of its PID namespace, the helper ignores signals it
+		// doesn't handle, so waiting for it would be pointless
+		sig = os.Kill
+	}
+	log.Debug("stopping process", "path", p.be.Filename, "pid", p.Pid(), "signal", sig, "timeout", timeout)
+	if err := p.signal(sig); err != nil {
+		log.Debug("unable to signal process group", "pid", p.Pid(), "error", err)
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	poll := time.NewTicker(50 * time.Millisecond)
+	defer poll.Stop()
+	for {
+		select {
+		case <-deadline.C:
+			log.Info("process didn't stop in time, killing it", "path", p.be.Filename, "pid", p.Pid(), "timeout", timeout)
+			if err := p.signal(os.Kill); err != nil {
+				log.Debug("unable to kill process group", "pid", p.Pid(), "error", err)
+			}
+			<-p.done
+			return
+		case <-poll.C:
+			// Wait for the whole group, not just the leader, so that its
+			// children also get a chance to shut down cleanly.
+			select {
+			case <-p.done:
+				if !p.groupAlive() {
+					return
+				}
+			default:
+			}
+		}
+	}
+}
+
+// signal sends sig to the process group, or only to the process if it's
+// unverified.
+func (p *Process) signal(sig os.Signal) error {
+	if p.unverified {
+		return p.proc.Signal(sig)
+	}
+	return signalGroup(p.proc, sig)
+}
+
+// groupAlive checks whether any member of the process group is still running.
+// For an unverified Process, only the process itself counts, which is gone
+// once done is closed.
+func (p *Process) groupAlive() bool {
+	return !p.unverified && groupAlive(p.proc)
+}
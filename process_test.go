@@ -0,0 +1,66 @@
+//go:build !windows
+// +build !windows
+
+package byteexec
+
+import (
+	"bufio"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStopGraceful(t *testing.T) {
+	be := testHelper(t, Options{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cmd := be.Command("term")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := be.Start(ctx, cmd)
+	if err != nil {
+		t.Fatalf("Unable to start helper: %v", err)
+	}
+	out := bufio.NewReader(stdout)
+	waitForLine(t, out, "ready")
+
+	cancel()
+	waitForLine(t, out, "terminating")
+	assert.NoError(t, p.Wait(), "Helper should have exited cleanly on SIGTERM")
+	assert.NoError(t, p.Stop(), "Stopping again should be harmless")
+}
+
+func TestStopEscalates(t *testing.T) {
+	be := testHelper(t, Options{StopTimeout: 200 * time.Millisecond})
+	cmd := be.Command("ignoreterm", "spawn")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := be.Start(context.Background(), cmd)
+	if err != nil {
+		t.Fatalf("Unable to start helper: %v", err)
+	}
+	out := bufio.NewReader(stdout)
+	line, err := out.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Unable to read child pid: %v", err)
+	}
+	childPid, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		t.Fatalf("Unable to parse child pid %q: %v", line, err)
+	}
+	waitForLine(t, out, "ready")
+
+	start := time.Now()
+	assert.Error(t, p.Stop(), "Helper should have been killed")
+	assert.True(t, time.Since(start) >= 200*time.Millisecond, "Helper should have been given the grace period")
+	assert.True(t, eventually(func() bool { return processGone(childPid) }, 2*time.Second), "Grandchild should have been killed along with the helper")
+}
@@ -0,0 +1,63 @@
+//go:build !windows
+// +build !windows
+
+package byteexec
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+const defaultStopSignal = syscall.SIGTERM
+
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+func signalGroup(proc *os.Process, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return proc.Signal(sig)
+	}
+	return syscall.Kill(-proc.Pid, s)
+}
+
+func killGroup(proc *os.Process) error {
+	return syscall.Kill(-proc.Pid, syscall.SIGKILL)
+}
+
+// groupAlive checks whether any member of proc's process group is still
+// running.
+func groupAlive(proc *os.Process) bool {
+	return syscall.Kill(-proc.Pid, 0) == nil
+}
+
+// dupFile returns a duplicate of f that stays open when f is closed.
+func dupFile(f *os.File) (*os.File, error) {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var dup int
+	var dupErr error
+	err = conn.Control(func(fd uintptr) {
+		// Don't let children started in the meantime inherit it
+		syscall.ForkLock.RLock()
+		defer syscall.ForkLock.RUnlock()
+		dup, dupErr = syscall.Dup(int(fd))
+		if dupErr == nil {
+			syscall.CloseOnExec(dup)
+		}
+	})
+	if err == nil {
+		err = dupErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(dup), f.Name()), nil
+}
@@ -0,0 +1,52 @@
+package byteexec
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Windows can't deliver signals other than Kill, so stopping always kills.
+var defaultStopSignal = os.Kill
+
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+}
+
+func signalGroup(proc *os.Process, sig os.Signal) error {
+	return proc.Kill()
+}
+
+func killGroup(proc *os.Process) error {
+	return proc.Kill()
+}
+
+// Windows has no way to check on the rest of a process group, so we only go by
+// the leader.
+func groupAlive(proc *os.Process) bool {
+	return false
+}
+
+// dupFile returns a duplicate of f that stays open when f is closed.
+func dupFile(f *os.File) (*os.File, error) {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var dup syscall.Handle
+	var dupErr error
+	err = conn.Control(func(fd uintptr) {
+		self, _ := syscall.GetCurrentProcess()
+		dupErr = syscall.DuplicateHandle(self, syscall.Handle(fd), self, &dup, 0, false, syscall.DUPLICATE_SAME_ACCESS)
+	})
+	if err == nil {
+		err = dupErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(dup), f.Name()), nil
+}
@@ -0,0 +1,81 @@
+package byteexec
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// processExecutable returns the path of the executable that the process with
+// the given pid runs.
+func processExecutable(pid int) (string, error) {
+	path, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	return strings.TrimSuffix(path, " (deleted)"), err
+}
+
+// processInfo returns the start time (in clock ticks since boot) and process
+// group of the process with the given pid. Zombies count as not running.
+func processInfo(pid int) (startTime uint64, pgid int, err error) {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+	// The command name in parentheses may contain spaces, so we only split
+	// what comes after it, starting with field 3 (state).
+	end := strings.LastIndexByte(string(stat), ')')
+	if end < 0 {
+		return 0, 0, fmt.Errorf("unable to parse /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 20 {
+		return 0, 0, fmt.Errorf("unable to parse /proc/%d/stat", pid)
+	}
+	if fields[0] == "Z" || fields[0] == "X" {
+		return 0, 0, errors.New("process is a zombie")
+	}
+	pgid, err = strconv.Atoi(fields[2])
+	if err != nil {
+		return 0, 0, err
+	}
+	startTime, err = strconv.ParseUint(fields[19], 10, 64)
+	return startTime, pgid, err
+}
+
+// killOrphan kills the orphaned helper with the given pid along with its
+// process group if it leads one.
+func killOrphan(pid int) error {
+	_, pgid, err := processInfo(pid)
+	if err != nil {
+		return err
+	}
+	if pgid == pid {
+		return syscall.Kill(-pid, syscall.SIGKILL)
+	}
+	return syscall.Kill(pid, syscall.SIGKILL)
+}
+
+// catchesSignal reports whether the process with the given pid has a handler
+// for sig. It errs on the side of true if that can't be determined.
+func catchesSignal(pid int, sig os.Signal) bool {
+	signum, ok := sig.(syscall.Signal)
+	if !ok || signum < 1 || signum > 64 {
+		return true
+	}
+	status, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return true
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		if mask, found := strings.CutPrefix(line, "SigCgt:"); found {
+			caught, err := strconv.ParseUint(strings.TrimSpace(mask), 16, 64)
+			if err != nil {
+				return true
+			}
+			return caught&(1<<(signum-1)) != 0
+		}
+	}
+	return true
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+// +build !linux
+
+package byteexec
+
+import (
+	"errors"
+	"os"
+)
+
+// Identifying processes by start time is only supported on Linux.
+func processExecutable(pid int) (string, error) {
+	return "", errors.ErrUnsupported
+}
+
+func processInfo(pid int) (startTime uint64, pgid int, err error) {
+	return 0, 0, errors.ErrUnsupported
+}
+
+func killOrphan(pid int) error {
+	return errors.ErrUnsupported
+}
+
+func catchesSignal(pid int, sig os.Signal) bool {
+	return true
+}
@@ -0,0 +1,8 @@
+//go:build race
+// +build race
+
+package byteexec
+
+// raceEnabled reports whether the tests run under the race detector, which
+// doesn't work with a limited address space.
+const raceEnabled = true
@@ -3,6 +3,10 @@
 
 package byteexec
 
+import (
+	"os"
+)
+
 func renameExecutable(orig string) string {
 	return orig
 }
@@ -10,3 +14,7 @@ func renameExecutable(orig string) string {
 func pathForRelativeFiles() (string, error) {
 	return inHomeDir("Library/Application Support/byteexec")
 }
+
+func isExecutable(mode os.FileMode) bool {
+	return mode&0111 != 0
+}
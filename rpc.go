@@ -0,0 +1,472 @@
+package byteexec
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// The RPC subsystem talks JSON-RPC 2.0 with a long-lived helper over its stdin
+// and stdout. Every message is framed by its length as a 4 byte big-endian
+// unsigned integer followed by that many bytes of JSON. Helpers written in Go
+// can use ServeRPC to implement their side of the protocol.
+
+// Defaults for RPCOptions.
+const (
+	DefaultRPCTimeout   = 30 * time.Second
+	DefaultMaxFrameSize = 16 * 1024 * 1024
+)
+
+// Error codes defined by JSON-RPC 2.0.
+const (
+	RPCParseError     = -32700
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+)
+
+var (
+	// ErrRPCClosed is returned by RPCClient.Call when the client was closed or
+	// the helper exited before answering.
+	ErrRPCClosed = errors.New("rpc connection closed")
+
+	// ErrFrameTooLarge is returned when a frame exceeds the maximum size.
+	ErrFrameTooLarge = errors.New("rpc frame too large")
+)
+
+// RPCError is an error reported by the helper in a JSON-RPC 2.0 response.
+type RPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *uint64         `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *uint64         `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// writeFrame writes msg as a single frame.
+func writeFrame(w io.Writer, msg any) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[4:], payload)
+	_, err = w.Write(frame)
+	return err
+}
+
+// readFrame reads a single frame of at most maxSize bytes.
+func readFrame(r io.Reader, maxSize int) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if int64(size) > int64(maxSize) {
+		return nil, fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return payload, nil
+}
+
+// RPCOptions configures an RPCClient. Zero values are replaced with the
+// corresponding defaults.
+type RPCOptions struct {
+	// Args are the arguments passed to the helper.
+	Args []string
+
+	// Timeout bounds calls whose context has no deadline.
+	Timeout time.Duration
+
+	// MaxFrameSize is the largest response accepted from the helper.
+	MaxFrameSize int
+}
+
+// RPCClient makes JSON-RPC 2.0 calls to a helper running as a co-process. Any
+// number of calls can be in flight at the same time. If the helper exits, the
+// calls in flight fail with ErrRPCClosed and the next call restarts it.
+// RPCClient is safe for concurrent use.
+type RPCClient struct {
+	be     *Exec
+	opts   RPCOptions
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	conn   *rpcConn
+	nextID uint64
+	closed bool
+}
+
+// StartRPC starts the helper as a co-process and returns an RPCClient for
+// talking to it.
+func (be *Exec) StartRPC(opts RPCOptions) (*RPCClient, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultRPCTimeout
+	}
+	if opts.MaxFrameSize <= 0 {
+		opts.MaxFrameSize = DefaultMaxFrameSize
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &RPCClient{be: be, opts: opts, ctx: ctx, cancel: cancel}
+	if _, err := c.connection(); err != nil {
+		cancel()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Process returns the currently running helper, or nil if there isn't one.
+func (c *RPCClient) Process() *Process {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.p
+}
+
+// Call calls method on the helper with the given params and decodes the result
+// into result, which may be nil to ignore it. If ctx has no deadline, the call
+// times out after RPCOptions.Timeout. Errors reported by the helper are
+// returned as *RPCError.
+func (c *RPCClient) Call(ctx context.Context, method string, params any, result any) error {
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.Timeout)
+		defer cancel()
+	}
+
+	var rawParams json.RawMessage
+	if params != nil {
+		var err error
+		rawParams, err = json.Marshal(params)
+		if err != nil {
+			return fmt.Errorf("unable to encode params: %w", err)
+		}
+	}
+
+	conn, err := c.connection()
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.nextID++
+	id := c.nextID
+	c.mu.Unlock()
+
+	resp, err := conn.call(ctx, &rpcRequest{JSONRPC: "2.0", ID: &id, Method: method, Params: rawParams})
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result != nil {
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			return fmt.Errorf("unable to decode result: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close stops the helper. Calls in flight fail with ErrRPCClosed.
+func (c *RPCClient) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	conn := c.conn
+	c.mu.Unlock()
+	c.cancel()
+	if conn != nil {
+		<-conn.done
+	}
+	return nil
+}
+
+// connection returns the connection to the running helper, starting a new one
+// if necessary.
+func (c *RPCClient) connection() (*rpcConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrRPCClosed
+	}
+	if c.conn != nil {
+		select {
+		case <-c.conn.done:
+			c.be.logger().Info("restarting rpc helper", "path", c.be.Filename, "error", c.conn.err)
+		default:
+			return c.conn, nil
+		}
+	}
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	return conn, nil
+}
+
+// rpcConn is the connection to a single run of the helper.
+type rpcConn struct {
+	p        *Process
+	maxFrame int
+
+	// writeSem serializes writes to stdin, like a mutex that can be given up
+	// on
+	writeSem chan struct{}
+	stdin    *os.File
+
+	mu      sync.Mutex
+	pending map[uint64]chan *rpcResponse
+	done    chan struct{}
+	err     error
+}
+
+func (c *RPCClient) dial() (*rpcConn, error) {
+	cmd := c.be.Command(c.opts.Args...)
+	// Use our own pipes, since the Process waits on cmd right away, which
+	// would close a pipe from StdoutPipe while we still read from it, and
+	// since writes to stdin need deadlines.
+	pr, stdin, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, pw, err := os.Pipe()
+	if err != nil {
+		pr.Close()
+		stdin.Close()
+		return nil, err
+	}
+	cmd.Stdin = pr
+	cmd.Stdout = pw
+	p, err := c.be.Start(c.ctx, cmd)
+	pr.Close()
+	pw.Close()
+	if err != nil {
+		stdin.Close()
+		stdout.Close()
+		return nil, err
+	}
+
+	conn := &rpcConn{
+		p:        p,
+		maxFrame: c.opts.MaxFrameSize,
+		writeSem: make(chan struct{}, 1),
+		stdin:    stdin,
+		pending:  make(map[uint64]chan *rpcResponse),
+		done:     make(chan struct{}),
+	}
+	go conn.read(stdout, c.be.logger())
+	return conn, nil
+}
+
+func (conn *rpcConn) call(ctx context.Context, req *rpcRequest) (*rpcResponse, error) {
+	responses := make(chan *rpcResponse, 1)
+	conn.mu.Lock()
+	if conn.err != nil {
+		conn.mu.Unlock()
+		return nil, conn.err
+	}
+	conn.pending[*req.ID] = responses
+	conn.mu.Unlock()
+	defer func() {
+		conn.mu.Lock()
+		delete(conn.pending, *req.ID)
+		conn.mu.Unlock()
+	}()
+
+	if err := conn.write(ctx, req); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-responses:
+		return resp, nil
+	case <-conn.done:
+		// The response may have arrived just before the connection closed
+		select {
+		case resp := <-responses:
+			return resp, nil
+		default:
+			return nil, conn.err
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// write writes req to the helper's stdin, giving up once ctx is done. Since a
+// partially written frame leaves the stream unusable, the helper is stopped if
+// the write fails.
+func (conn *rpcConn) write(ctx context.Context, req *rpcRequest) error {
+	select {
+	case conn.writeSem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-conn.done:
+		return conn.err
+	}
+	defer func() { <-conn.writeSem }()
+
+	var mu sync.Mutex
+	writing := true
+	stop := context.AfterFunc(ctx, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if writing {
+			// Interrupts the write
+			conn.stdin.SetWriteDeadline(time.Now())
+		}
+	})
+	err := writeFrame(conn.stdin, req)
+	stop()
+	mu.Lock()
+	writing = false
+	mu.Unlock()
+	if err == nil {
+		// ctx may have been done right after the write completed
+		conn.stdin.SetWriteDeadline(time.Time{})
+		return nil
+	}
+	conn.p.be.logger().Error("stopping rpc helper after failed write", "path", conn.p.be.Filename, "pid", conn.p.Pid(), "error", err)
+	conn.stdin.Close()
+	go conn.p.Stop()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return fmt.Errorf("%w: %w", ErrRPCClosed, err)
+}
+
+// read dispatches responses to their callers until the helper closes its
+// stdout, then fails the remaining calls.
+func (conn *rpcConn) read(stdout io.ReadCloser, log Logger) {
+	var err error
+	for {
+		var frame []byte
+		frame, err = readFrame(stdout, conn.maxFrame)
+		if err != nil {
+			break
+		}
+		var resp rpcResponse
+		if err := json.Unmarshal(frame, &resp); err != nil || resp.ID == nil {
+			log.Debug("ignoring invalid rpc response", "pid", conn.p.Pid(), "frame", string(frame), "error", err)
+			continue
+		}
+		conn.mu.Lock()
+		responses := conn.pending[*resp.ID]
+		conn.mu.Unlock()
+		if responses != nil {
+			select {
+			case responses <- &resp:
+			default:
+				log.Debug("ignoring duplicate rpc response", "pid", conn.p.Pid(), "id", *resp.ID)
+			}
+		}
+	}
+
+	// Whatever went wrong, the helper is no use to us anymore
+	stdout.Close()
+	conn.stdin.Close()
+	conn.p.Stop()
+	if err == io.EOF {
+		err = conn.p.Wait()
+	}
+	conn.mu.Lock()
+	if err != nil {
+		conn.err = fmt.Errorf("%w: %w", ErrRPCClosed, err)
+	} else {
+		conn.err = ErrRPCClosed
+	}
+	conn.mu.Unlock()
+	close(conn.done)
+}
+
+// RPCHandler handles a call to method with the given raw JSON params. The
+// result is encoded as JSON. Errors of type *RPCError are passed to the caller
+// as they are, other errors are reported as internal errors.
+type RPCHandler func(method string, params json.RawMessage) (any, error)
+
+// ServeRPC implements the helper's side of the protocol used by RPCClient. It
+// reads requests from r, normally os.Stdin, and writes responses to w, normally
+// os.Stdout. Requests are handled concurrently. ServeRPC returns nil once r is
+// exhausted.
+func ServeRPC(r io.Reader, w io.Writer, handler RPCHandler) error {
+	var writeMu sync.Mutex
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	respond := func(resp *rpcResponse) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		writeFrame(w, resp)
+	}
+
+	for {
+		frame, err := readFrame(r, DefaultMaxFrameSize)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req rpcRequest
+		if err := json.Unmarshal(frame, &req); err != nil {
+			respond(&rpcResponse{JSONRPC: "2.0", Error: &RPCError{Code: RPCParseError, Message: err.Error()}})
+			continue
+		}
+		if req.ID == nil {
+			// Notifications don't get responses
+			go handler(req.Method, req.Params)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := &rpcResponse{JSONRPC: "2.0", ID: req.ID}
+			result, err := handler(req.Method, req.Params)
+			if err == nil {
+				resp.Result, err = json.Marshal(result)
+			}
+			if err != nil {
+				var rpcErr *RPCError
+				if !errors.As(err, &rpcErr) {
+					rpcErr = &RPCError{Code: RPCInternalError, Message: err.Error()}
+				}
+				resp.Result = nil
+				resp.Error = rpcErr
+			}
+			respond(resp)
+		}()
+	}
+}
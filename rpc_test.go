@@ -0,0 +1,103 @@
+//go:build !windows
+// +build !windows
+
+package byteexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRPC(t *testing.T) {
+	be := testHelper(t, Options{})
+	client, err := be.StartRPC(RPCOptions{Args: []string{"rpc"}})
+	if err != nil {
+		t.Fatalf("Unable to start helper: %v", err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	var echoed map[string]string
+	if assert.NoError(t, client.Call(ctx, "echo", map[string]string{"a": "b"}, &echoed)) {
+		assert.Equal(t, map[string]string{"a": "b"}, echoed)
+	}
+
+	// Calls in flight at the same time are answered as they finish
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 1; i <= 5; i++ {
+		wg.Add(1)
+		go func(ms int) {
+			defer wg.Done()
+			var result int
+			if assert.NoError(t, client.Call(ctx, "sleep", ms, &result)) {
+				assert.Equal(t, ms, result)
+			}
+		}(i * 100)
+	}
+	wg.Wait()
+	assert.Less(t, time.Since(start), 1500*time.Millisecond, "calls should run concurrently")
+
+	var rpcErr *RPCError
+	if assert.ErrorAs(t, client.Call(ctx, "nonexistent", nil, nil), &rpcErr) {
+		assert.Equal(t, RPCMethodNotFound, rpcErr.Code)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, client.Call(timeoutCtx, "sleep", 1000, nil), context.DeadlineExceeded)
+
+	// A crash fails the call and the next call restarts the helper
+	pid := client.Process().Pid()
+	assert.ErrorIs(t, client.Call(ctx, "crash", nil, nil), ErrRPCClosed)
+	assert.NoError(t, client.Call(ctx, "echo", 1, nil))
+	assert.NotEqual(t, pid, client.Process().Pid())
+
+	client.Close()
+	assert.True(t, errors.Is(client.Call(ctx, "echo", 1, nil), ErrRPCClosed))
+}
+
+func TestRPCWriteTimeout(t *testing.T) {
+	be := testHelper(t, Options{})
+	client, err := be.StartRPC(RPCOptions{Args: []string{"hang"}, Timeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Unable to start helper: %v", err)
+	}
+	defer client.Close()
+	pid := client.Process().Pid()
+
+	// More than fits into the pipe's buffer, from several callers at once
+	params := strings.Repeat("x", 1<<20)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Error(t, client.Call(context.Background(), "echo", params, nil))
+		}()
+	}
+	wg.Wait()
+	assert.Less(t, time.Since(start), 2*time.Second, "calls shouldn't block past their timeout")
+	assert.True(t, eventually(func() bool { return processGone(pid) }, 5*time.Second), "helper should have been stopped")
+}
+
+func TestFrames(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, writeFrame(&buf, "hello"))
+	assert.Equal(t, []byte{0, 0, 0, 7}, buf.Bytes()[:4])
+	frame, err := readFrame(&buf, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, `"hello"`, string(frame))
+
+	writeFrame(&buf, "hello")
+	_, err = readFrame(&buf, 3)
+	assert.ErrorIs(t, err, ErrFrameTooLarge)
+}
@@ -0,0 +1,220 @@
+package byteexec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// RunSpec describes a single run of a helper with Exec.Run.
+type RunSpec struct {
+	// Args are the arguments passed to the helper.
+	Args []string
+
+	// Stdin, if set, is connected to the helper's standard input.
+	Stdin io.Reader
+
+	// Dir is the working directory of the helper. If empty, the helper runs
+	// in the calling process's current directory.
+	Dir string
+
+	// Timeout, if positive, stops the helper once it has run for this long.
+	Timeout time.Duration
+
+	// OutputLimit is the number of bytes kept from both the start and the end
+	// of each of the helper's output streams. If zero, DefaultCaptureLimit is
+	// used.
+	OutputLimit int
+
+	// Env are variables of the form "key=value" added to the helper's
+	// environment, replacing variables of the same name. See Exec.Environ.
+	Env []string
+
+	// Secrets are passed to the helper through inherited file descriptors,
+	// see Exec.PassSecrets. Args may refer to them as "{fd:Name}".
+	Secrets []Secret
+}
+
+// Result describes a completed run of a helper.
+type Result struct {
+	// ExitCode is the exit code of the helper, or -1 if it was terminated by
+	// a signal.
+	ExitCode int
+
+	// Signal is the signal that terminated the helper, if any. It's always nil
+	// on Windows.
+	Signal os.Signal
+
+	// TimedOut indicates that the helper was stopped because it exceeded
+	// RunSpec.Timeout.
+	TimedOut bool
+
+	// Wall is the time between starting the helper and its exit.
+	Wall time.Duration
+
+	// User and System are the CPU time the helper spent in user and kernel
+	// mode.
+	User   time.Duration
+	System time.Duration
+
+	// MaxRSS is the helper's peak resident set size in bytes, where the
+	// platform reports it.
+	MaxRSS int64
+
+	// MemoryPeak is the peak memory usage of the helper and its descendants
+	// in bytes, and OOMKills the number of processes the kernel's OOM killer
+	// killed among them. They're only reported for helpers confined with
+	// Options.Cgroup, on kernels that provide the counters.
+	MemoryPeak int64
+	OOMKills   int
+
+	// Stdout and Stderr hold the helper's output. If the helper wrote more
+	// than twice RunSpec.OutputLimit to a stream, only the start and the end
+	// are kept and StdoutDropped or StderrDropped count the bytes in between.
+	Stdout        []byte
+	Stderr        []byte
+	StdoutDropped int64
+	StderrDropped int64
+
+	// Cached indicates that the result was served from a Cache instead of
+	// running the helper.
+	Cached bool
+}
+
+// Success reports whether the helper exited with code 0.
+func (r *Result) Success() bool {
+	return r.ExitCode == 0
+}
+
+// maxErrorStderr is the number of bytes from the end of the helper's stderr
+// that are included in the message of an ExitError.
+const maxErrorStderr = 512
+
+// ExitError is the error returned by Run when the helper exits with a non-zero
+// code or is terminated by a signal. It wraps context.DeadlineExceeded if the
+// helper timed out.
+type ExitError struct {
+	*Result
+
+	// StderrTail is the end of the helper's stderr, up to RunSpec.OutputLimit
+	// bytes. The message of the error includes its last few lines.
+	StderrTail []byte
+}
+
+func (e *ExitError) Error() string {
+	var msg string
+	switch {
+	case e.TimedOut:
+		msg = fmt.Sprintf("helper timed out after %v", e.Wall)
+	case e.Signal != nil:
+		msg = fmt.Sprintf("helper terminated by signal: %v", e.Signal)
+	default:
+		msg = fmt.Sprintf("helper exited with code %d", e.ExitCode)
+	}
+	stderr := bytes.TrimSpace(e.StderrTail)
+	if len(stderr) == 0 {
+		return msg
+	}
+	if len(stderr) > maxErrorStderr {
+		stderr = stderr[len(stderr)-maxErrorStderr:]
+		if i := bytes.IndexByte(stderr, '\n'); i >= 0 {
+			stderr = stderr[i+1:]
+		}
+		return fmt.Sprintf("%s: stderr: ...%s", msg, stderr)
+	}
+	return fmt.Sprintf("%s: stderr: %s", msg, stderr)
+}
+
+func (e *ExitError) Unwrap() error {
+	if e.TimedOut {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// Run runs the helper as described by spec and waits for it to exit. If the
+// helper can't be started, Run returns only an error, which wraps
+// ErrAlreadyRunning if Start attached to a running instance instead. Otherwise
+// it returns a Result, along with an *ExitError if the helper didn't exit
+// successfully, or another error if copying its stdin failed. When ctx is done
+// or the timeout elapses, the helper is stopped like with Process.Stop.
+func (be *Exec) Run(ctx context.Context, spec RunSpec) (*Result, error) {
+	runCtx := ctx
+	if spec.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, spec.Timeout)
+		defer cancel()
+	}
+
+	limit := spec.OutputLimit
+	if limit <= 0 {
+		limit = DefaultCaptureLimit
+	}
+	stdout, stderr := NewCapture(limit, limit), NewCapture(limit, limit)
+	cmd := be.Command(spec.Args...)
+	cmd.Stdin = spec.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Dir = spec.Dir
+	if spec.Env != nil {
+		if cmd.Env == nil {
+			cmd.Env = be.Environ()
+		}
+		cmd.Env = mergeEnv(cmd.Env, spec.Env)
+	}
+	release := func() {}
+	if len(spec.Secrets) > 0 {
+		var err error
+		release, err = be.PassSecrets(cmd, spec.Secrets...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	start := time.Now()
+	p, err := be.Start(runCtx, cmd)
+	release()
+	if err != nil {
+		return nil, err
+	}
+	if p.Cmd == nil {
+		// Attached to an instance started elsewhere, so there's nothing to
+		// capture and no exit status to report
+		return nil, fmt.Errorf("%w with pid %d", ErrAlreadyRunning, p.Pid())
+	}
+	waitErr := p.Wait()
+
+	state := cmd.ProcessState
+	if state == nil {
+		return nil, waitErr
+	}
+	result := &Result{
+		ExitCode:      state.ExitCode(),
+		TimedOut:      ctx.Err() == nil && runCtx.Err() == context.DeadlineExceeded,
+		Wall:          time.Since(start),
+		User:          state.UserTime(),
+		System:        state.SystemTime(),
+		Stdout:        stdout.Bytes(),
+		Stderr:        stderr.Bytes(),
+		StdoutDropped: stdout.Dropped(),
+		StderrDropped: stderr.Dropped(),
+		MemoryPeak:    p.usage.memoryPeak,
+		OOMKills:      p.usage.oomKills,
+	}
+	fillSysUsage(result, state)
+	be.logger().Debug("run finished", "path", be.Filename, "pid", p.Pid(), "exit_code", result.ExitCode, "signal", result.Signal,
+		"timed_out", result.TimedOut, "wall", result.Wall, "user", result.User, "system", result.System, "max_rss", result.MaxRSS,
+		"memory_peak", result.MemoryPeak, "oom_kills", result.OOMKills)
+
+	if !result.Success() || result.TimedOut {
+		return result, &ExitError{Result: result, StderrTail: stderr.Tail()}
+	}
+	if waitErr != nil {
+		// The helper succeeded, but copying its input or output failed
+		return result, fmt.Errorf("unable to run helper: %w", waitErr)
+	}
+	return result, nil
+}
@@ -0,0 +1,72 @@
+//go:build !windows
+// +build !windows
+
+package byteexec
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	be := testHelper(t, Options{})
+	result, err := be.Run(context.Background(), RunSpec{Args: []string{"exit", "3"}})
+	var exitErr *ExitError
+	if assert.True(t, errors.As(err, &exitErr), "Run should have returned an ExitError, got %v", err) {
+		assert.Equal(t, result, exitErr.Result)
+	}
+	assert.Equal(t, 3, result.ExitCode)
+	assert.Nil(t, result.Signal)
+	assert.False(t, result.TimedOut)
+	assert.Equal(t, "stdout\n", string(result.Stdout))
+	assert.Equal(t, "stderr\n", string(result.Stderr))
+	assert.True(t, result.Wall > 0)
+	assert.True(t, result.MaxRSS > 0, "Should have recorded peak memory usage")
+
+	result, err = be.Run(context.Background(), RunSpec{Args: []string{"cat"}, Stdin: strings.NewReader("input")})
+	assert.NoError(t, err)
+	assert.Equal(t, "input", string(result.Stdout))
+
+	result, err = be.Run(context.Background(), RunSpec{Args: []string{"cat"}, Stdin: iotest.ErrReader(errors.New("broken stdin"))})
+	if assert.ErrorContains(t, err, "broken stdin") {
+		assert.True(t, result.Success())
+	}
+}
+
+func TestRunAttached(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	be := testHelper(t, Options{SingleInstance: &SingleInstance{OnConflict: ConflictAttach}})
+	p, err := be.Start(context.Background(), be.Command("sleep"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+	_, err = be.Run(context.Background(), RunSpec{Args: []string{"exit", "0"}})
+	assert.True(t, errors.Is(err, ErrAlreadyRunning), "Run shouldn't attach, got %v", err)
+}
+
+func TestRunBoundedOutput(t *testing.T) {
+	be := testHelper(t, Options{})
+	result, err := be.Run(context.Background(), RunSpec{Args: []string{"spew", "1000000"}, OutputLimit: 100})
+	assert.Len(t, result.Stderr, 200)
+	assert.EqualValues(t, 1000000+len("\nfailed\n")-200, result.StderrDropped)
+	if assert.Error(t, err) {
+		assert.True(t, strings.HasSuffix(err.Error(), "xxxx\nfailed"), "Error should include the end of stderr, got %v", err)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	be := testHelper(t, Options{})
+	result, err := be.Run(context.Background(), RunSpec{Args: []string{"sleep"}, Timeout: 100 * time.Millisecond})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "Run should have timed out, got %v", err)
+	assert.True(t, result.TimedOut)
+	assert.Equal(t, -1, result.ExitCode)
+	assert.Equal(t, syscall.SIGTERM, result.Signal)
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package byteexec
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+func fillSysUsage(result *Result, state *os.ProcessState) {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		result.Signal = status.Signal()
+	}
+	if usage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		result.MaxRSS = int64(usage.Maxrss)
+		if runtime.GOOS != "darwin" {
+			// Everywhere but on macOS, ru_maxrss is in kilobytes
+			result.MaxRSS *= 1024
+		}
+	}
+}
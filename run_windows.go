@@ -0,0 +1,9 @@
+package byteexec
+
+import (
+	"os"
+)
+
+// Windows reports neither signals nor peak memory usage.
+func fillSysUsage(result *Result, state *os.ProcessState) {
+}
@@ -0,0 +1,95 @@
+package byteexec
+
+//go:generate go run mkseccomp.go amd64
+//go:generate go run mkseccomp.go arm64
+
+// SeccompProfile is a built-in list of syscalls allowed by a Seccomp filter.
+type SeccompProfile string
+
+const (
+	// SeccompComputeOnly allows what a program needs to compute: memory
+	// management, threads, signals, clocks and reading and writing files.
+	// It doesn't allow networking or starting processes other than threads.
+	SeccompComputeOnly SeccompProfile = "compute-only"
+
+	// SeccompNetworkClient allows everything SeccompComputeOnly does, plus
+	// opening outgoing network connections. It doesn't allow listening for
+	// incoming ones.
+	SeccompNetworkClient SeccompProfile = "network-client"
+)
+
+// Seccomp is a seccomp-bpf filter for helpers. It's installed right before the
+// helper is executed and stays in place for the helper and all of its
+// descendants. Syscalls that aren't allowed kill the helper with SIGSYS, or
+// fail with EPERM if Errno is set. Since the filter is installed before the
+// helper is executed, execve is always allowed, and so is prlimit64 for the
+// calling process, which the Go runtime uses right before. Seccomp filters are
+// only supported on Linux on amd64 and arm64, and imply no_new_privs. The
+// syscall numbers are generated from golang.org/x/sys/unix by mkseccomp.go.
+type Seccomp struct {
+	// Profile is the built-in list of allowed syscalls to start from. If
+	// empty, only the syscalls in Allow are allowed.
+	Profile SeccompProfile
+
+	// Allow lists additional syscalls to allow by name, for example
+	// "socket".
+	Allow []string
+
+	// Errno makes syscalls that aren't allowed fail with EPERM instead of
+	// killing the helper.
+	Errno bool
+}
+
+// seccompProfiles are the syscalls allowed by each profile. Syscalls that
+// don't exist on the architecture are ignored.
+var seccompProfiles = map[SeccompProfile][]string{
+	SeccompComputeOnly: computeOnlySyscalls,
+	SeccompNetworkClient: append(append([]string(nil), computeOnlySyscalls...),
+		"socket", "connect", "bind", "getsockname", "getpeername", "getsockopt", "setsockopt",
+		"sendto", "recvfrom", "sendmsg", "recvmsg", "sendmmsg", "recvmmsg", "shutdown"),
+}
+
+var computeOnlySyscalls = []string{
+	// Processes and threads. clone is only allowed for threads, see
+	// seccompFilter for the arguments it checks.
+	"execve", "exit", "exit_group", "clone", "set_tid_address", "set_robust_list", "get_robust_list", "rseq",
+	"arch_prctl", "prctl", "gettid", "getpid", "getppid", "getuid", "geteuid", "getgid", "getegid",
+	"getgroups", "getresuid", "getresgid", "getpgrp", "getpgid", "getsid", "uname", "sysinfo",
+	"getrlimit", "getrusage", "times", "getpriority", "capget",
+	"sched_yield", "sched_getaffinity", "sched_setaffinity", "sched_getparam", "sched_getscheduler",
+
+	// Memory
+	"brk", "mmap", "munmap", "mremap", "mprotect", "madvise", "mlock", "munlock", "membarrier",
+
+	// Signals
+	"rt_sigaction", "rt_sigprocmask", "rt_sigreturn", "rt_sigsuspend", "rt_sigtimedwait", "sigaltstack",
+	"tgkill", "pause", "alarm", "restart_syscall",
+
+	// Synchronization and time
+	"futex", "nanosleep", "clock_nanosleep", "clock_gettime", "clock_getres", "gettimeofday", "time",
+	"setitimer", "getitimer", "timer_create", "timer_settime", "timer_gettime", "timer_delete",
+	"getrandom",
+
+	// Files and descriptors
+	"read", "write", "readv", "writev", "pread64", "pwrite64", "preadv", "pwritev", "lseek", "close",
+	"open", "openat", "creat", "stat", "fstat", "lstat", "newfstatat", "statx", "statfs", "fstatfs",
+	"access", "faccessat", "faccessat2", "readlink", "readlinkat", "getcwd", "chdir", "fchdir",
+	"getdents64", "mkdir", "mkdirat", "unlink", "unlinkat", "rename", "renameat", "renameat2",
+	"ftruncate", "fsync", "fdatasync", "fallocate", "fadvise64", "umask", "fchmod", "utimensat",
+	"dup", "dup2", "dup3", "fcntl", "flock", "pipe", "pipe2", "eventfd2",
+	"epoll_create", "epoll_create1", "epoll_ctl", "epoll_wait", "epoll_pwait", "epoll_pwait2",
+	"poll", "ppoll", "select", "pselect6", "sendfile", "splice", "copy_file_range",
+	"memfd_create", "close_range", "ioctl",
+
+	// Waiting for children, which only exist if they were started before
+	// the filter was installed
+	"wait4", "waitid",
+}
+
+// bpfInstruction is a classic BPF instruction, like struct sock_filter.
+type bpfInstruction struct {
+	Code uint16
+	Jt   uint8
+	Jf   uint8
+	K    uint32
+}
@@ -0,0 +1,135 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package byteexec
+
+import (
+	"fmt"
+	"sort"
+
+	"golang.org/x/sys/unix"
+)
+
+// Offsets of the fields of struct seccomp_data. Arguments are 64 bits wide,
+// the offsets point to their lower halves on these little-endian
+// architectures.
+const (
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	seccompDataArg0 = 16
+	seccompDataArg1 = 24
+)
+
+// Syscall numbers from 0x40000000 on are the x32 ABI on amd64.
+const x32SyscallBit = 0x40000000
+
+// seccompFilter compiles the BPF program for spec.
+func seccompFilter(spec *Seccomp) ([]bpfInstruction, error) {
+	allowed := make(map[uint32]bool)
+	if spec.Profile != "" {
+		names, found := seccompProfiles[spec.Profile]
+		if !found {
+			return nil, fmt.Errorf("unknown seccomp profile %q", spec.Profile)
+		}
+		for _, name := range names {
+			// Profiles cover all architectures, not every syscall exists on
+			// every one of them
+			if nr, found := syscallNumbers[name]; found {
+				allowed[nr] = true
+			}
+		}
+	}
+	for _, name := range spec.Allow {
+		nr, found := syscallNumbers[name]
+		if !found {
+			return nil, fmt.Errorf("unknown syscall %q", name)
+		}
+		allowed[nr] = true
+	}
+	allowed[unix.SYS_EXECVE] = true
+
+	deny := uint32(unix.SECCOMP_RET_KILL_PROCESS)
+	if spec.Errno {
+		deny = unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)
+	}
+	load := func(offset uint32) bpfInstruction {
+		return bpfInstruction{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: offset}
+	}
+	ret := func(action uint32) bpfInstruction {
+		return bpfInstruction{Code: unix.BPF_RET | unix.BPF_K, K: action}
+	}
+	// jumpIfEqual skips the next instruction unless the accumulator equals k
+	jumpIfEqual := func(k uint32) bpfInstruction {
+		return bpfInstruction{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 0, Jf: 1, K: k}
+	}
+
+	program := []bpfInstruction{
+		// Syscall numbers only make sense for the architecture they're for
+		load(seccompDataArch),
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 1, Jf: 0, K: auditArch},
+		ret(unix.SECCOMP_RET_KILL_PROCESS),
+		load(seccompDataNr),
+		{Code: unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K, Jt: 0, Jf: 1, K: x32SyscallBit},
+		ret(deny),
+	}
+
+	// clone3 passes its flags in memory where the filter can't check them.
+	// ENOSYS makes the C library fall back to clone.
+	program = append(program, jumpIfEqual(unix.SYS_CLONE3), ret(unix.SECCOMP_RET_ERRNO|uint32(unix.ENOSYS)))
+	if allowed[unix.SYS_CLONE] && !spec.allowsProcesses() {
+		// Only allow clone for threads
+		delete(allowed, unix.SYS_CLONE)
+		program = append(program,
+			bpfInstruction{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 0, Jf: 4, K: unix.SYS_CLONE},
+			load(seccompDataArg0),
+			bpfInstruction{Code: unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K, Jt: 0, Jf: 1, K: unix.CLONE_THREAD},
+			ret(unix.SECCOMP_RET_ALLOW),
+			ret(deny),
+		)
+	}
+	if !allowed[unix.SYS_PRLIMIT64] {
+		// syscall.Exec restores the original limit on open files with
+		// prlimit64, so allow it as long as it only affects the caller
+		program = append(program,
+			bpfInstruction{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 0, Jf: 4, K: unix.SYS_PRLIMIT64},
+			load(seccompDataArg0),
+			bpfInstruction{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 0, Jf: 1, K: 0},
+			ret(unix.SECCOMP_RET_ALLOW),
+			ret(deny),
+		)
+	}
+	if allowed[unix.SYS_IOCTL] {
+		// Don't allow faking terminal input, which would let the helper run
+		// commands in the terminal it was started from
+		delete(allowed, unix.SYS_IOCTL)
+		program = append(program,
+			bpfInstruction{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 0, Jf: 5, K: unix.SYS_IOCTL},
+			load(seccompDataArg1),
+			bpfInstruction{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 2, Jf: 0, K: unix.TIOCSTI},
+			bpfInstruction{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 1, Jf: 0, K: unix.TIOCLINUX},
+			ret(unix.SECCOMP_RET_ALLOW),
+			ret(deny),
+		)
+	}
+
+	numbers := make([]uint32, 0, len(allowed))
+	for nr := range allowed {
+		numbers = append(numbers, nr)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	for _, nr := range numbers {
+		program = append(program, jumpIfEqual(nr), ret(unix.SECCOMP_RET_ALLOW))
+	}
+	return append(program, ret(deny)), nil
+}
+
+// allowsProcesses reports whether spec explicitly allows starting processes.
+func (spec *Seccomp) allowsProcesses() bool {
+	for _, name := range spec.Allow {
+		if name == "clone" || name == "fork" || name == "vfork" {
+			return true
+		}
+	}
+	return false
+}
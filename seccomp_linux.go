@@ -0,0 +1,25 @@
+package byteexec
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// installSeccomp installs the seccomp filter for the calling thread. Filters
+// need no_new_privs, unless the caller has CAP_SYS_ADMIN.
+func installSeccomp(program []bpfInstruction) error {
+	filters := make([]unix.SockFilter, len(program))
+	for i, instruction := range program {
+		filters[i] = unix.SockFilter{Code: instruction.Code, Jt: instruction.Jt, Jf: instruction.Jf, K: instruction.K}
+	}
+	fprog := unix.SockFprog{Len: uint16(len(filters)), Filter: &filters[0]}
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return err
+	}
+	_, _, errno := unix.RawSyscall(unix.SYS_PRCTL, unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&fprog)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
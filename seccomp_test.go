@@ -0,0 +1,54 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package byteexec
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeccomp(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer l.Close()
+	addr := l.Addr().String()
+	ctx := context.Background()
+
+	be := testHelper(t, Options{Seccomp: &Seccomp{Profile: SeccompComputeOnly}})
+	_, err = be.Run(ctx, RunSpec{Args: []string{"exit", "0"}})
+	assert.NoError(t, err, "helper should be able to run with compute-only profile")
+	result, err := be.Run(ctx, RunSpec{Args: []string{"dial", addr}})
+	if assert.Error(t, err) {
+		assert.Equal(t, syscall.SIGSYS, result.Signal, "forbidden syscall should kill helper")
+	}
+
+	be = testHelper(t, Options{Seccomp: &Seccomp{Profile: SeccompComputeOnly, Errno: true}})
+	result, err = be.Run(ctx, RunSpec{Args: []string{"dial", addr}})
+	if assert.Error(t, err) {
+		assert.Contains(t, string(result.Stderr), "operation not permitted")
+	}
+	result, err = be.Run(ctx, RunSpec{Args: []string{"spawn"}})
+	if assert.Error(t, err, "helper shouldn't be able to start processes") {
+		assert.Contains(t, string(result.Stderr), "operation not permitted")
+	}
+
+	be = testHelper(t, Options{Seccomp: &Seccomp{Profile: SeccompNetworkClient}})
+	_, err = be.Run(ctx, RunSpec{Args: []string{"dial", addr}})
+	assert.NoError(t, err, "network client should be able to connect")
+
+	be = testHelper(t, Options{Seccomp: &Seccomp{Profile: SeccompComputeOnly, Allow: []string{"socket", "connect", "getsockopt", "setsockopt", "getsockname", "getpeername"}, Errno: true}})
+	_, err = be.Run(ctx, RunSpec{Args: []string{"dial", addr}})
+	assert.NoError(t, err, "custom syscalls should be allowed")
+
+	be = testHelper(t, Options{Seccomp: &Seccomp{Allow: []string{"nonexistent"}}})
+	_, err = be.Run(ctx, RunSpec{Args: []string{"exit", "0"}})
+	assert.ErrorContains(t, err, "unknown syscall")
+}
@@ -0,0 +1,16 @@
+//go:build !linux || !(amd64 || arm64)
+// +build !linux !amd64,!arm64
+
+package byteexec
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+// Seccomp filters are only supported on architectures that we have syscall
+// tables for.
+func seccompFilter(spec *Seccomp) ([]bpfInstruction, error) {
+	return nil, fmt.Errorf("byteexec: seccomp on %s/%s: %w", runtime.GOOS, runtime.GOARCH, errors.ErrUnsupported)
+}
@@ -0,0 +1,78 @@
+package byteexec
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Secret is data handed to a helper through an inherited file descriptor, so
+// that it doesn't show up in the helper's command line or environment, which
+// other local users can read from /proc. The helper reads the secret from the
+// descriptor until EOF.
+type Secret struct {
+	// Name identifies the secret in arguments, where "{fd:Name}" is replaced
+	// with the number of the descriptor.
+	Name string
+
+	// Data is the secret.
+	Data []byte
+
+	// Env, if set, is the name of an environment variable that is set to the
+	// number of the descriptor.
+	Env string
+}
+
+// PassSecrets hands secrets to the helper started by cmd through inherited
+// file descriptors. On Linux, each secret is passed in a sealed memfd, and
+// elsewhere in a pipe. The descriptors' numbers replace "{fd:Name}" in cmd's
+// arguments and are set in the environment variables named by Secret.Env.
+// Once cmd has been started, or failed to start, call release to close this
+// process's copies of the descriptors. Passing secrets isn't supported on
+// Windows.
+func (be *Exec) PassSecrets(cmd *exec.Cmd, secrets ...Secret) (release func(), err error) {
+	var files []*os.File
+	release = func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}
+	for _, secret := range secrets {
+		file, err := sealedFile("byteexec-secret-"+secret.Name, secret.Data)
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("byteexec: unable to pass secret %s: %w", secret.Name, err)
+		}
+		files = append(files, file)
+		// ExtraFiles start at descriptor 3, after stdin, stdout and stderr
+		cmd.ExtraFiles = append(cmd.ExtraFiles, file)
+		fd := strconv.Itoa(2 + len(cmd.ExtraFiles))
+		for i := 1; i < len(cmd.Args); i++ {
+			cmd.Args[i] = strings.ReplaceAll(cmd.Args[i], "{fd:"+secret.Name+"}", fd)
+		}
+		if secret.Env != "" {
+			if cmd.Env == nil {
+				cmd.Env = be.Environ()
+			}
+			cmd.Env = mergeEnv(cmd.Env, []string{secret.Env + "=" + fd})
+		}
+	}
+	return release, nil
+}
+
+// pipeSecret returns the read end of a pipe that yields data. The data is
+// written in the background, since it may not fit into the pipe's buffer.
+func pipeSecret(data []byte) (*os.File, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		// Fails once the reader is gone without reading everything
+		w.Write(data)
+		w.Close()
+	}()
+	return r, nil
+}
@@ -0,0 +1,73 @@
+//go:build !windows
+// +build !windows
+
+package byteexec
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecrets(t *testing.T) {
+	be := testHelper(t, Options{Env: &EnvPolicy{}})
+	ctx := context.Background()
+	secrets := []Secret{
+		{Name: "token", Data: []byte("s3cr3t")},
+		{Name: "key", Data: []byte("private key"), Env: "KEY_FD"},
+	}
+
+	result, err := be.Run(ctx, RunSpec{Args: []string{"readfd", "{fd:token}"}, Secrets: secrets})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "s3cr3t", string(result.Stdout))
+		assert.Empty(t, string(result.Stderr), "secret shouldn't be writable")
+	}
+	result, err = be.Run(ctx, RunSpec{Args: []string{"env"}, Secrets: secrets})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "KEY_FD=4\nLANG=C\nTZ=UTC\n", string(result.Stdout))
+	}
+
+	cmd := be.Command("readfd", "{fd:key}")
+	release, err := be.PassSecrets(cmd, secrets...)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{be.Filename, "readfd", "4"}, cmd.Args)
+	output, err := cmd.Output()
+	release()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "private key", string(output))
+	}
+}
+
+func TestSecretsShim(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("The shim is only supported on Linux")
+	}
+	be := testHelper(t, Options{Limits: &Limits{OpenFiles: 100}})
+	result, err := be.Run(context.Background(), RunSpec{
+		Args:    []string{"readfd", "{fd:token}"},
+		Secrets: []Secret{{Name: "token", Data: []byte("s3cr3t")}},
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "s3cr3t", string(result.Stdout))
+	}
+}
+
+func TestPipeSecret(t *testing.T) {
+	// More than fits into the pipe's buffer
+	data := bytes.Repeat([]byte("secret"), 100000)
+	r, err := pipeSecret(data)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer r.Close()
+	read, err := io.ReadAll(r)
+	if assert.NoError(t, err) {
+		assert.Equal(t, data, read)
+	}
+}
@@ -0,0 +1,12 @@
+package byteexec
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// os/exec doesn't support ExtraFiles on Windows.
+func sealedFile(name string, data []byte) (*os.File, error) {
+	return nil, fmt.Errorf("passing secrets on windows: %w", errors.ErrUnsupported)
+}
@@ -0,0 +1,172 @@
+package byteexec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Some settings for helpers, such as Limits, have to be applied between fork
+// and exec, which the os/exec package doesn't allow. For those, Command starts
+// the calling executable instead of the helper, passing it the encoded
+// shimSpec in the shimEnv environment variable. Package initialization
+// recognizes the variable, applies the settings and then replaces the process
+// with the helper, keeping its pid, its file descriptors and its arguments.
+// The shim's variables are removed from the helper's environment. Since the
+// spec only lives in the command's environment, nothing needs to be cleaned up
+// however the command is run, or if it never is.
+//
+// The shim takes over during this package's initialization, so the init
+// functions of packages initialized before it, such as its dependencies, also
+// run in the shim, before any of the settings are applied. They shouldn't
+// have side effects beyond the process itself.
+
+// shimEnv is the environment variable with the encoded spec.
+const shimEnv = "BYTEEXEC_SHIM"
+
+// shimErrorsEnv is the environment variable with the descriptor of the pipe
+// on which the shim reports setup errors, if Start provided one. Unlike the
+// spec, the pipe only exists while Start waits for the shim.
+const shimErrorsEnv = "BYTEEXEC_SHIM_ERRORS"
+
+// shimExitCode is the exit code of the shim when it fails to set up or execute
+// the helper. It's the same one shells use for commands that can't be found or
+// executed.
+const shimExitCode = 127
+
+// shimSpec tells the shim how to set up the helper.
+type shimSpec struct {
+	Path       string
+	Namespaces *Namespaces      `json:",omitempty"`
+	Limits     *Limits          `json:",omitempty"`
+	Landlock   *landlockSpec    `json:",omitempty"`
+	Privileges *privilegesSpec  `json:",omitempty"`
+	Seccomp    []bpfInstruction `json:",omitempty"`
+}
+
+// shimSpec returns the spec for starting the helper through the shim, or nil if
+// it can be started directly.
+func (be *Exec) shimSpec() (*shimSpec, error) {
+	spec := &shimSpec{Path: be.Filename, Limits: be.opts.Limits}
+	var err error
+	if be.opts.Namespaces != nil {
+		spec.Namespaces, err = resolveNamespaces(be.opts.Namespaces)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if be.opts.Landlock != nil {
+		spec.Landlock, err = be.landlockSpec()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if be.opts.Privileges != nil {
+		if spec.Namespaces != nil && spec.Namespaces.User {
+			// Only the calling user is mapped in the user namespace
+			return nil, errors.New("byteexec: can't drop privileges in a user namespace")
+		}
+		// Resolved when the Exec was created
+		spec.Privileges = be.privileges
+	}
+	if be.opts.Seccomp != nil {
+		spec.Seccomp, err = seccompFilter(be.opts.Seccomp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if spec.Namespaces == nil && spec.Limits == nil && spec.Landlock == nil && spec.Privileges == nil && spec.Seccomp == nil {
+		return nil, nil
+	}
+	return spec, nil
+}
+
+// useShim makes cmd start the helper through the shim, if necessary. Errors are
+// reported through cmd.Err, so that they surface when cmd is started.
+func (be *Exec) useShim(cmd *exec.Cmd) {
+	spec, err := be.shimSpec()
+	if err != nil {
+		cmd.Err = err
+		return
+	}
+	if spec == nil {
+		return
+	}
+	if spec.Namespaces != nil {
+		setNamespaces(cmd, spec.Namespaces)
+	}
+	useShimSpec(cmd, spec)
+}
+
+// useShimSpec makes cmd start through the shim with the given spec.
+func useShimSpec(cmd *exec.Cmd, spec *shimSpec) {
+	encoded, err := json.Marshal(spec)
+	if err != nil {
+		cmd.Err = err
+		return
+	}
+	path, err := shimPath()
+	if err != nil {
+		cmd.Err = err
+		return
+	}
+	cmd.Path = path
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = mergeEnv(cmd.Env, []string{shimEnv + "=" + string(encoded)})
+}
+
+// usesShim reports whether cmd starts the helper through the shim.
+func usesShim(cmd *exec.Cmd) bool {
+	for _, kv := range cmd.Env {
+		if strings.HasPrefix(kv, shimEnv+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// shimErrors receives the setup errors of a shim started by Start.
+type shimErrors struct {
+	r, w *os.File
+}
+
+// watchShim asks the shim that cmd starts, if any, to report setup errors on a
+// pipe, so that Start can fail with them instead of the helper just exiting
+// with shimExitCode.
+func watchShim(cmd *exec.Cmd) (*shimErrors, error) {
+	if !usesShim(cmd) {
+		return nil, nil
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("byteexec: unable to watch shim: %w", err)
+	}
+	// ExtraFiles start at descriptor 3, after stdin, stdout and stderr
+	cmd.ExtraFiles = append(cmd.ExtraFiles, w)
+	cmd.Env = mergeEnv(cmd.Env, []string{shimErrorsEnv + "=" + strconv.Itoa(2+len(cmd.ExtraFiles))})
+	return &shimErrors{r: r, w: w}, nil
+}
+
+// wait closes this process's copy of the write end of the pipe and, if the
+// shim started, waits until it either executed the helper or failed, in which
+// case it returns the error the shim reported.
+func (se *shimErrors) wait(started bool) error {
+	se.w.Close()
+	defer se.r.Close()
+	if !started {
+		return nil
+	}
+	// The shim's copy of the write end is closed on exec
+	msg, _ := io.ReadAll(se.r)
+	if len(msg) > 0 {
+		return fmt.Errorf("byteexec: %s", msg)
+	}
+	return nil
+}
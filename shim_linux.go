@@ -0,0 +1,102 @@
+package byteexec
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func init() {
+	if encoded, found := os.LookupEnv(shimEnv); found {
+		runShim(encoded)
+	}
+}
+
+// shimErrorsFile is where the shim reports setup errors, if Start asked for
+// them. It's nil otherwise.
+var shimErrorsFile *os.File
+
+// shimPath returns the path at which the shim can be executed, which is the
+// calling executable.
+func shimPath() (string, error) {
+	return "/proc/self/exe", nil
+}
+
+// runShim sets up the process as described by the encoded spec and executes
+// the helper with the same arguments. It never returns.
+func runShim(encoded string) {
+	// Settings such as the seccomp filter are per thread
+	runtime.LockOSThread()
+	if fd, found := os.LookupEnv(shimErrorsEnv); found {
+		if n, err := strconv.Atoi(fd); err == nil {
+			// Don't let the helper inherit it, so that the parent sees EOF once
+			// the helper has been executed
+			syscall.CloseOnExec(n)
+			shimErrorsFile = os.NewFile(uintptr(n), "byteexec-shim-errors")
+		}
+	}
+	os.Unsetenv(shimEnv)
+	os.Unsetenv(shimErrorsEnv)
+	var spec shimSpec
+	if err := json.Unmarshal([]byte(encoded), &spec); err != nil {
+		shimFail(fmt.Errorf("invalid spec: %w", err))
+	}
+	if spec.Namespaces != nil {
+		if err := spec.Namespaces.setup(spec.Path); err != nil {
+			shimFail(err)
+		}
+	}
+	if spec.Path == "" {
+		// Just checking whether the setup works
+		os.Exit(0)
+	}
+	if spec.Limits != nil {
+		if err := spec.Limits.apply(); err != nil {
+			shimFail(err)
+		}
+	}
+	if spec.Landlock != nil {
+		if err := spec.Landlock.apply(); err != nil {
+			shimFail(err)
+		}
+	}
+	path := spec.Path
+	if spec.Privileges != nil {
+		// Open the helper while we can still reach it, since its directory
+		// is usually only accessible to us
+		fd, err := syscall.Open(spec.Path, unix.O_PATH|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			shimFail(fmt.Errorf("unable to open %s: %w", spec.Path, err))
+		}
+		path = fmt.Sprintf("/proc/self/fd/%d", fd)
+		if err := spec.Privileges.apply(); err != nil {
+			shimFail(err)
+		}
+	}
+	// The filter only applies to the calling thread, which is the one that
+	// executes the helper. Install it last, so that the setup isn't subject
+	// to it.
+	if len(spec.Seccomp) > 0 {
+		if err := installSeccomp(spec.Seccomp); err != nil {
+			shimFail(fmt.Errorf("unable to install seccomp filter: %w", err))
+		}
+	}
+	err := syscall.Exec(path, os.Args, os.Environ())
+	shimFail(fmt.Errorf("unable to execute %s: %w", spec.Path, err))
+}
+
+// shimFail reports err to the parent, or on stderr if it isn't listening, and
+// exits.
+func shimFail(err error) {
+	if shimErrorsFile != nil {
+		fmt.Fprint(shimErrorsFile, err)
+	} else {
+		fmt.Fprintf(os.Stderr, "byteexec: %v\n", err)
+	}
+	os.Exit(shimExitCode)
+}
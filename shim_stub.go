@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package byteexec
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+// The shim is only supported on Linux.
+func shimPath() (string, error) {
+	return "", fmt.Errorf("byteexec: %w on %s", errors.ErrUnsupported, runtime.GOOS)
+}
@@ -0,0 +1,269 @@
+package byteexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// ErrCrashLoop is returned by Supervisor.Run when the helper failed too often
+// within SupervisorOptions.FailureWindow.
+var ErrCrashLoop = errors.New("helper is crash looping")
+
+// RestartPolicy determines when a Supervisor restarts a helper that exited.
+type RestartPolicy int
+
+const (
+	// RestartAlways restarts the helper whenever it exits.
+	RestartAlways RestartPolicy = iota
+	// RestartOnFailure restarts the helper only if it failed to start or
+	// exited with an error.
+	RestartOnFailure
+	// RestartNever runs the helper only once.
+	RestartNever
+)
+
+// Defaults for SupervisorOptions.
+const (
+	DefaultMinBackoff    = 100 * time.Millisecond
+	DefaultMaxBackoff    = 30 * time.Second
+	DefaultJitter        = 0.2
+	DefaultMaxFailures   = 5
+	DefaultFailureWindow = time.Minute
+)
+
+// SupervisorOptions configures a Supervisor. Zero values are replaced with the
+// corresponding defaults.
+type SupervisorOptions struct {
+	// Args are the arguments passed to the helper.
+	Args []string
+
+	// Command, if set, creates the command for each run of the helper instead
+	// of Args, for example to wire up its output. It must return a new
+	// exec.Cmd from the supervised Exec's Command method every time.
+	Command func() *exec.Cmd
+
+	// Policy determines whether the helper is restarted when it exits.
+	Policy RestartPolicy
+
+	// MinBackoff is the delay before the first restart. The delay doubles with
+	// each consecutive restart up to MaxBackoff, whether the helper failed or
+	// exited cleanly, so that a helper that keeps exiting right away isn't
+	// restarted in a tight loop. A run lasting longer than MaxBackoff resets
+	// the delay to MinBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Jitter randomizes each delay by up to this fraction in either direction,
+	// so that several supervisors don't restart in lockstep.
+	Jitter float64
+
+	// MaxFailures is the number of failures within FailureWindow after which
+	// the Supervisor gives up with ErrCrashLoop.
+	MaxFailures   int
+	FailureWindow time.Duration
+
+	// Readiness, if set, is probed after each start until it first succeeds,
+	// at which point the channel returned by Ready is closed. Otherwise the
+	// helper counts as ready as soon as it has started.
+	Readiness *HealthCheck
+
+	// Liveness, if set, is probed once the helper is ready. When it fails
+	// Liveness.FailureThreshold times in a row, the helper is stopped and the
+	// run fails with ErrUnhealthy.
+	Liveness *HealthCheck
+
+	// OnStart is called every time the helper has been started.
+	OnStart func(p *Process)
+
+	// OnExit is called every time the helper exited or failed to start, with
+	// the resulting error. p is nil if the helper failed to start.
+	OnExit func(p *Process, err error)
+
+	// OnGiveUp is called when the Supervisor stops restarting the helper
+	// because of a crash loop.
+	OnGiveUp func(err error)
+}
+
+// Supervisor keeps a long-running helper alive by restarting it according to a
+// RestartPolicy, with exponential backoff between restarts.
+type Supervisor struct {
+	be   *Exec
+	opts SupervisorOptions
+
+	mu      sync.Mutex
+	current *Process
+	ready   chan struct{}
+}
+
+// Supervise creates a Supervisor for this Exec. The helper isn't started until
+// Run is called.
+func (be *Exec) Supervise(opts SupervisorOptions) *Supervisor {
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultMinBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = opts.MinBackoff
+	}
+	if opts.Jitter <= 0 {
+		opts.Jitter = DefaultJitter
+	}
+	if opts.MaxFailures <= 0 {
+		opts.MaxFailures = DefaultMaxFailures
+	}
+	if opts.FailureWindow <= 0 {
+		opts.FailureWindow = DefaultFailureWindow
+	}
+	return &Supervisor{be: be, opts: opts, ready: make(chan struct{})}
+}
+
+// Process returns the currently running instance of the helper, or nil if
+// there isn't one.
+func (s *Supervisor) Process() *Process {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current
+}
+
+// Ready returns a channel that's closed once the current instance of the helper
+// is ready. After a restart, Ready returns a new channel for the new instance.
+func (s *Supervisor) Ready() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ready
+}
+
+// Run starts the helper and keeps restarting it until ctx is done, the restart
+// policy says not to, or the helper is crash looping. When ctx is done, the
+// running helper is stopped and Run returns ctx.Err(). When the policy ends
+// supervision, Run returns the result of the last run. A crash loop results in
+// an error wrapping ErrCrashLoop and the last failure.
+func (s *Supervisor) Run(ctx context.Context) error {
+	log := s.be.logger()
+	var failures []time.Time
+	backoff := s.opts.MinBackoff
+
+	for {
+		started := time.Now()
+		err := s.runOnce(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		ranFor := time.Since(started)
+
+		if !s.shouldRestart(err) {
+			return err
+		}
+		if err != nil {
+			failures = append(failures, time.Now())
+			failures = withinWindow(failures, s.opts.FailureWindow)
+			if len(failures) >= s.opts.MaxFailures {
+				err = fmt.Errorf("%w: %d failures within %v, last: %w", ErrCrashLoop, len(failures), s.opts.FailureWindow, err)
+				log.Error("giving up on helper", "path", s.be.Filename, "error", err)
+				if s.opts.OnGiveUp != nil {
+					s.opts.OnGiveUp(err)
+				}
+				return err
+			}
+		}
+
+		if ranFor > s.opts.MaxBackoff {
+			backoff = s.opts.MinBackoff
+		}
+		delay := jitter(backoff, s.opts.Jitter)
+		log.Info("restarting helper", "path", s.be.Filename, "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		backoff *= 2
+		if backoff > s.opts.MaxBackoff {
+			backoff = s.opts.MaxBackoff
+		}
+	}
+}
+
+func (s *Supervisor) shouldRestart(err error) bool {
+	switch s.opts.Policy {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return err != nil
+	default:
+		return false
+	}
+}
+
+// runOnce starts one instance of the helper and waits for it to exit.
+func (s *Supervisor) runOnce(ctx context.Context) error {
+	var cmd *exec.Cmd
+	if s.opts.Command != nil {
+		cmd = s.opts.Command()
+	} else {
+		cmd = s.be.Command(s.opts.Args...)
+	}
+	p, err := s.be.Start(ctx, cmd)
+	if err != nil {
+		if s.opts.OnExit != nil {
+			s.opts.OnExit(nil, err)
+		}
+		return err
+	}
+
+	s.mu.Lock()
+	s.current = p
+	ready := s.ready
+	s.mu.Unlock()
+	if s.opts.OnStart != nil {
+		s.opts.OnStart(p)
+	}
+
+	monitorCtx, cancel := context.WithCancel(ctx)
+	unhealthy := make(chan error, 1)
+	monitorDone := make(chan struct{})
+	go s.monitor(monitorCtx, p, ready, unhealthy, monitorDone)
+	err = p.Wait()
+	cancel()
+	// Once the monitor is done, nothing else closes ready
+	<-monitorDone
+	select {
+	case err = <-unhealthy:
+	default:
+	}
+
+	s.mu.Lock()
+	s.current = nil
+	select {
+	case <-ready:
+		s.ready = make(chan struct{})
+	default:
+	}
+	s.mu.Unlock()
+	if s.opts.OnExit != nil {
+		s.opts.OnExit(p, err)
+	}
+	return err
+}
+
+// withinWindow drops the times that are older than window.
+func withinWindow(times []time.Time, window time.Duration) []time.Time {
+	cutoff := time.Now().Add(-window)
+	i := 0
+	for i < len(times) && times[i].Before(cutoff) {
+		i++
+	}
+	return times[i:]
+}
+
+// jitter randomizes d by up to fraction in either direction.
+func jitter(d time.Duration, fraction float64) time.Duration {
+	return time.Duration(float64(d) * (1 + fraction*(2*rand.Float64()-1)))
+}
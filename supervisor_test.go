@@ -0,0 +1,89 @@
+//go:build !windows
+// +build !windows
+
+package byteexec
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisorCrashLoop(t *testing.T) {
+	be := testHelper(t, Options{})
+	var starts, exits int32
+	var gaveUp error
+	s := be.Supervise(SupervisorOptions{
+		Args:        []string{"exit", "1"},
+		Policy:      RestartOnFailure,
+		MinBackoff:  10 * time.Millisecond,
+		MaxBackoff:  40 * time.Millisecond,
+		MaxFailures: 3,
+		OnStart:     func(p *Process) { atomic.AddInt32(&starts, 1) },
+		OnExit:      func(p *Process, err error) { atomic.AddInt32(&exits, 1) },
+		OnGiveUp:    func(err error) { gaveUp = err },
+	})
+	err := s.Run(context.Background())
+	assert.True(t, errors.Is(err, ErrCrashLoop), "Supervisor should have detected crash loop, got %v", err)
+	assert.Equal(t, err, gaveUp)
+	assert.EqualValues(t, 3, starts)
+	assert.EqualValues(t, 3, exits)
+
+	// A successful run isn't restarted on failure
+	starts = 0
+	s = be.Supervise(SupervisorOptions{
+		Args:    []string{"exit", "0"},
+		Policy:  RestartOnFailure,
+		OnStart: func(p *Process) { atomic.AddInt32(&starts, 1) },
+	})
+	assert.NoError(t, s.Run(context.Background()))
+	assert.EqualValues(t, 1, starts)
+}
+
+func TestSupervisorAlways(t *testing.T) {
+	be := testHelper(t, Options{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started := make(chan *Process, 10)
+	s := be.Supervise(SupervisorOptions{
+		Args:       []string{"sleep"},
+		Policy:     RestartAlways,
+		MinBackoff: 10 * time.Millisecond,
+		OnStart:    func(p *Process) { started <- p },
+	})
+	result := make(chan error)
+	go func() { result <- s.Run(ctx) }()
+
+	// Kill the helper and make sure it gets restarted
+	first := <-started
+	first.Cmd.Process.Kill()
+	second := <-started
+	assert.NotEqual(t, first.Pid(), second.Pid())
+	assert.Equal(t, second, s.Process())
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-result)
+	<-second.Done()
+}
+
+func TestSupervisorCleanExitBackoff(t *testing.T) {
+	be := testHelper(t, Options{})
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	var starts int32
+	s := be.Supervise(SupervisorOptions{
+		Args:       []string{"exit", "0"},
+		Policy:     RestartAlways,
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: time.Second,
+		Jitter:     0.01,
+		OnStart:    func(p *Process) { atomic.AddInt32(&starts, 1) },
+	})
+	assert.Equal(t, context.DeadlineExceeded, s.Run(ctx))
+	// 10, 20, 40, 80, 160 and 320ms between restarts
+	assert.LessOrEqual(t, atomic.LoadInt32(&starts), int32(7), "Helper exiting right away should be restarted with backoff")
+}